@@ -1,17 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"regexp"
+	"time"
 
 	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/event"
 
 	validator "github.com/go-playground/validator/v10"
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var validate *validator.Validate
@@ -20,18 +23,46 @@ var validate *validator.Validate
 type API struct {
 	Port                string
 	AssignmentSubmitter assignment.Submitter
-
-	server *http.Server
+	AssignmentStore     assignment.Store
+	// Instrument sets the tick and lot sizes assignments submitted to the
+	// default market are validated against
+	Instrument assignment.Instrument
+	// Instruments are the other markets assignments can be submitted to,
+	// keyed by symbol
+	Instruments map[string]assignment.Instrument
+	// IdempotencyWindow is how long the response to a request with an
+	// Idempotency-Key header is replayed for when the request is repeated.
+	// Zero never replays responses.
+	IdempotencyWindow time.Duration
+	// IdempotencyCapacity is the most responses kept to replay, forgetting
+	// the least recently used. Defaults to 10000.
+	IdempotencyCapacity int
+	// Queue is the event queue readiness checks can reach, and Subscriptions
+	// are those on it that must be live for the server to be ready
+	Queue         event.HealthChecker
+	Subscriptions []event.Subscription
+
+	server      *http.Server
+	idempotency idempotencyCache
+	draining    int32
 }
 
 // Start initialises and runs the API in a separate goroutine (non-blocking)
 func (api *API) Start() error {
-	validate = validator.New()
-	validate.RegisterValidation("currency", validateCurrency)
+	validate = newValidator()
+	api.idempotency.capacity = api.IdempotencyCapacity
 
 	router := httprouter.New()
-	router.POST("/buy", api.buyHandler)
-	router.POST("/sell", api.sellHandler)
+	router.POST("/buy", instrumented(assignment.Buy, api.idempotent(api.buyHandler)))
+	router.POST("/sell", instrumented(assignment.Sell, api.idempotent(api.sellHandler)))
+	router.POST("/instruments/:symbol/buy", instrumented(assignment.Buy, api.idempotent(api.buyHandler)))
+	router.POST("/instruments/:symbol/sell", instrumented(assignment.Sell, api.idempotent(api.sellHandler)))
+	router.GET("/assignments", api.listAssignmentsHandler)
+	router.POST("/assignments/batch", api.idempotent(api.batchHandler))
+	router.GET("/assignments/:id", api.getAssignmentHandler)
+	router.GET("/healthz", api.healthHandler)
+	router.GET("/readyz", api.readyHandler)
+	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
 
 	api.server = &http.Server{Addr: fmt.Sprintf(":%s", api.Port), Handler: router}
 
@@ -41,46 +72,93 @@ func (api *API) Start() error {
 	}
 
 	go func() {
-		// TODO: Change logger to critical
-		log.Printf("Server stopped: %s", api.server.Serve(ln))
+		if err := api.server.Serve(ln); err != http.ErrServerClosed {
+			// TODO: Change logger to critical
+			log.Printf("Server stopped: %s", err)
+		}
 	}()
 
 	return nil
 }
 
-func validateCurrency(fl validator.FieldLevel) bool {
-	res, _ := regexp.MatchString(`^([0-9])*\.([0-9]{2})$`, fl.Field().String())
-	return res
+// Shutdown stops the API accepting new requests and waits for in-flight
+// requests to finish, or for ctx to be done - whichever comes first
+func (api *API) Shutdown(ctx context.Context) error {
+	api.Drain()
+	return api.server.Shutdown(ctx)
+}
+
+func (api *API) buyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	api.assignmentHandler(w, r, ps.ByName("symbol"), assignment.Buy)
 }
 
-func (api *API) buyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	api.assignmentHandler(w, r, assignment.Buy)
+func (api *API) sellHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	api.assignmentHandler(w, r, ps.ByName("symbol"), assignment.Sell)
 }
 
-func (api *API) sellHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	api.assignmentHandler(w, r, assignment.Sell)
+// instrument returns the instrument for symbol, where an empty symbol is the
+// default market
+func (api *API) instrument(symbol string) (assignment.Instrument, error) {
+	if symbol == "" {
+		return api.Instrument, nil
+	}
+
+	i, ok := api.Instruments[symbol]
+	if !ok {
+		return assignment.Instrument{}, fmt.Errorf("%w %q", assignment.ErrUnknownInstrument, symbol)
+	}
+	return i, nil
 }
 
-func (api *API) assignmentHandler(w http.ResponseWriter, r *http.Request, t assignment.Type) {
+func (api *API) assignmentHandler(w http.ResponseWriter, r *http.Request, symbol string, t assignment.Type) {
+	instrument, err := api.instrument(symbol)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
 	assignment := assignment.Assignment{}
 	body := json.NewDecoder(r.Body)
 	if err := body.Decode(&assignment); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		handleError(w, fmt.Errorf("%w: %s", errBody, err))
 		return
 	}
+	assignment.Instrument = symbol
 
-	if err := validate.Struct(&assignment); err != nil {
-		// TODO: Change logger
-		log.Printf("Validation failed on assignment: %s\n", err)
-		w.WriteHeader(http.StatusBadRequest)
+	if err := validateAssignment(r.Context(), &assignment, instrument); err != nil {
+		handleError(w, err)
 		return
 	}
 
-	err := api.AssignmentSubmitter.SubmitAssignment(assignment, t)
+	submitted, err := api.submit(r.Context(), assignment, t)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, submitted)
+}
+
+// submit submits the assignment, keying its message by the request's
+// idempotency key if it has one
+func (api *API) submit(ctx context.Context, a assignment.Assignment, t assignment.Type) (assignment.Assignment, error) {
+	key := messageKey(ctx, 0)
+	if key == "" {
+		return api.AssignmentSubmitter.SubmitAssignment(a, t)
+	}
+
+	result := api.AssignmentSubmitter.SubmitAssignments([]assignment.Submission{{Assignment: a, Type: t, Key: key}})[0]
+	return result.Assignment, result.Err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
 }
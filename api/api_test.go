@@ -14,6 +14,7 @@ import (
 	mock_assignment "github.com/stevestotter/assignment-server/mocks/assignment"
 
 	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/decimal"
 
 	"testing"
 
@@ -30,15 +31,20 @@ func TestBuyWhenValidPriceAndQuantity(t *testing.T) {
 
 	reqJSON := `{"price": "2.24", "quantity": "0.5"}`
 	expAssignment := assignment.Assignment{
-		Price:    "2.24",
-		Quantity: "0.5",
+		Price:    decimal.MustParse("2.24"),
+		Quantity: decimal.MustParse("0.5"),
+	}
+	submitted := assignment.Assignment{
+		ID:       7,
+		Price:    decimal.MustParse("2.24"),
+		Quantity: decimal.MustParse("0.5"),
 	}
 
 	mockSubmitter := mock_assignment.NewMockSubmitter(ctrl)
 	mockSubmitter.EXPECT().
 		SubmitAssignment(expAssignment, assignment.Buy).
 		Times(1).
-		Return(nil)
+		Return(submitted, nil)
 
 	a := API{Port: apiPort, AssignmentSubmitter: mockSubmitter}
 	err := a.Start()
@@ -57,7 +63,10 @@ func TestBuyWhenValidPriceAndQuantity(t *testing.T) {
 	assert.NoError(t, err)
 	defer resp.Body.Close()
 
+	body, _ := ioutil.ReadAll(resp.Body)
+
 	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.JSONEq(t, `{"id": 7, "price": "2.24", "quantity": "0.5"}`, string(body))
 }
 
 func TestBuyReturnsBadRequestWhenInvalidPriceAndQuantity(t *testing.T) {
@@ -115,7 +124,7 @@ func TestBuyReturnsServerErrorIfMessageQueueReturnsError(t *testing.T) {
 	mockSubmitter.EXPECT().
 		SubmitAssignment(gomock.Any(), gomock.Any()).
 		Times(1).
-		Return(errors.New("queue error"))
+		Return(assignment.Assignment{}, errors.New("queue error"))
 
 	a := API{Port: apiPort, AssignmentSubmitter: mockSubmitter}
 
@@ -149,15 +158,20 @@ func TestSellWhenValidPriceAndQuantity(t *testing.T) {
 
 	reqJSON := `{"price": "2.24", "quantity": "0.5"}`
 	expAssignment := assignment.Assignment{
-		Price:    "2.24",
-		Quantity: "0.5",
+		Price:    decimal.MustParse("2.24"),
+		Quantity: decimal.MustParse("0.5"),
+	}
+	submitted := assignment.Assignment{
+		ID:       7,
+		Price:    decimal.MustParse("2.24"),
+		Quantity: decimal.MustParse("0.5"),
 	}
 
 	mockSubmitter := mock_assignment.NewMockSubmitter(ctrl)
 	mockSubmitter.EXPECT().
 		SubmitAssignment(expAssignment, assignment.Sell).
 		Times(1).
-		Return(nil)
+		Return(submitted, nil)
 
 	a := API{Port: apiPort, AssignmentSubmitter: mockSubmitter}
 
@@ -177,7 +191,10 @@ func TestSellWhenValidPriceAndQuantity(t *testing.T) {
 	assert.NoError(t, err)
 	defer resp.Body.Close()
 
+	body, _ := ioutil.ReadAll(resp.Body)
+
 	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.JSONEq(t, `{"id": 7, "price": "2.24", "quantity": "0.5"}`, string(body))
 }
 
 func TestSellReturnsBadRequestWhenInvalidPriceAndQuantity(t *testing.T) {
@@ -235,7 +252,7 @@ func TestSellReturnsServerErrorIfMessageQueueReturnsError(t *testing.T) {
 	mockSubmitter.EXPECT().
 		SubmitAssignment(gomock.Any(), gomock.Any()).
 		Times(1).
-		Return(errors.New("queue error"))
+		Return(assignment.Assignment{}, errors.New("queue error"))
 
 	a := API{Port: apiPort, AssignmentSubmitter: mockSubmitter}
 
@@ -262,3 +279,28 @@ func TestSellReturnsServerErrorIfMessageQueueReturnsError(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
 }
+
+func TestShutdownStopsAcceptingRequests(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSubmitter := mock_assignment.NewMockSubmitter(ctrl)
+	a := API{Port: apiPort, AssignmentSubmitter: mockSubmitter}
+
+	err := a.Start()
+	assert.NoError(t, err)
+
+	err = a.Shutdown(context.Background())
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST",
+		fmt.Sprintf("http://localhost:%s/buy", apiPort),
+		bytes.NewBufferString(`{"price": "2.24", "quantity": "0.5"}`),
+	)
+	req.Close = true
+	assert.NoError(t, err)
+
+	httpClient := &http.Client{}
+	_, err = httpClient.Do(req)
+	assert.Error(t, err)
+}
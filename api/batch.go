@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/stevestotter/assignment-server/assignment"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxBatchSize = 1000
+
+const (
+	// allOrNothing submits a batch only if every assignment in it is valid.
+	// Publishing isn't atomic, so if it fails partway the assignments
+	// published before it are still accepted.
+	allOrNothing = "all-or-nothing"
+	// bestEffort submits the valid assignments in a batch, and rejects the
+	// rest
+	bestEffort = "best-effort"
+)
+
+// errAborted is given for a valid assignment that wasn't submitted because
+// others in its all-or-nothing batch were invalid
+var errAborted = errors.New("Batch aborted")
+
+// BatchRequest is a batch of buy and sell assignments to submit together.
+// Mode is either all-or-nothing, the default, or best-effort.
+type BatchRequest struct {
+	Mode        string            `json:"mode"`
+	Assignments []json.RawMessage `json:"assignments"`
+}
+
+// BatchItem is an assignment in a batch, with its type
+type BatchItem struct {
+	Type *assignment.Type `json:"type"`
+	assignment.Assignment
+}
+
+// BatchResult is what happened to each assignment in a batch, in the order
+// they were given. Results are either accepted, with the submitted
+// assignment, or rejected, with the error that stopped it being submitted.
+type BatchResult struct {
+	Results []ItemResult `json:"results"`
+}
+
+// ItemResult is what happened to an assignment in a batch
+type ItemResult struct {
+	Status     string                 `json:"status"`
+	Assignment *assignment.Assignment `json:"assignment,omitempty"`
+	Error      *Error                 `json:"error,omitempty"`
+}
+
+func (api *API) batchHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req := BatchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, fmt.Errorf("%w: %s", errBody, err))
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = allOrNothing
+	}
+	if req.Mode != allOrNothing && req.Mode != bestEffort {
+		handleError(w, fmt.Errorf("%w: unknown mode %q, expected %s or %s", errBody, req.Mode, allOrNothing, bestEffort))
+		return
+	}
+	if len(req.Assignments) == 0 || len(req.Assignments) > maxBatchSize {
+		handleError(w, fmt.Errorf("%w: a batch must have between 1 and %d assignments", errBody, maxBatchSize))
+		return
+	}
+
+	errs := make([]error, len(req.Assignments))
+	valid := []int{}
+	batch := []assignment.Submission{}
+	for i, raw := range req.Assignments {
+		s, err := api.batchSubmission(r, raw)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		s.Key = messageKey(r.Context(), i)
+		valid = append(valid, i)
+		batch = append(batch, s)
+	}
+
+	submitted := make([]assignment.Assignment, len(req.Assignments))
+	accepted := 0
+	if len(valid) < len(req.Assignments) && req.Mode == allOrNothing {
+		for _, i := range valid {
+			errs[i] = fmt.Errorf("%w: other assignments in the batch are invalid", errAborted)
+		}
+	} else if len(batch) > 0 {
+		var submitErr error
+		for j, result := range api.AssignmentSubmitter.SubmitAssignments(batch) {
+			i := valid[j]
+			if result.Err != nil {
+				errs[i] = result.Err
+				if submitErr == nil {
+					submitErr = result.Err
+				}
+				continue
+			}
+			submitted[i] = result.Assignment
+			accepted++
+		}
+		if submitErr != nil {
+			// TODO: Change logger
+			log.Printf("Failed to submit batch: %s\n", submitErr)
+		}
+	}
+
+	writeJSON(w, batchStatus(accepted, len(req.Assignments), errs), results(errs, submitted))
+}
+
+// batchSubmission decodes and validates an assignment in a batch
+func (api *API) batchSubmission(r *http.Request, raw json.RawMessage) (assignment.Submission, error) {
+	item := BatchItem{}
+	if err := json.Unmarshal(raw, &item); err != nil {
+		return assignment.Submission{}, fmt.Errorf("%w: %s", errBody, err)
+	}
+	if item.Type == nil {
+		return assignment.Submission{}, fieldErrors{{Field: "type", Rule: "required", Message: "type is required"}}
+	}
+	item.ID = 0
+
+	instrument, err := api.instrument(item.Instrument)
+	if err != nil {
+		return assignment.Submission{}, err
+	}
+	if err := validateAssignment(r.Context(), &item.Assignment, instrument); err != nil {
+		return assignment.Submission{}, err
+	}
+
+	return assignment.Submission{Assignment: item.Assignment, Type: *item.Type}, nil
+}
+
+// results gives the error of each assignment in the batch that has one, and
+// the submitted assignment of each that doesn't
+func results(errs []error, submitted []assignment.Assignment) BatchResult {
+	res := BatchResult{Results: make([]ItemResult, len(errs))}
+	for i, err := range errs {
+		if err != nil {
+			apiErr := errorFor(err)
+			res.Results[i] = ItemResult{Status: "rejected", Error: &apiErr}
+			continue
+		}
+		res.Results[i] = ItemResult{Status: "accepted", Assignment: &submitted[i]}
+	}
+	return res
+}
+
+// batchStatus is 202 if every assignment was accepted, 207 if only some were,
+// and the status of the first error if none were
+func batchStatus(accepted, total int, errs []error) int {
+	switch {
+	case accepted == total:
+		return http.StatusAccepted
+	case accepted > 0:
+		return http.StatusMultiStatus
+	}
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, errAborted) && !errors.Is(err, assignment.ErrNotSubmitted) {
+			return errorFor(err).Status
+		}
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/event"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func submitBatch(t *testing.T, api *API, body string) (*http.Response, BatchResult) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/assignments/batch", bytes.NewBufferString(body))
+	api.batchHandler(w, r, nil)
+
+	result := BatchResult{}
+	resp := w.Result()
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	return resp, result
+}
+
+func statuses(res BatchResult) []string {
+	s := []string{}
+	for _, r := range res.Results {
+		s = append(s, r.Status)
+	}
+	return s
+}
+
+func TestBatchSubmitsMixedBuyAndSellAssignments(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp, res := submitBatch(t, api, `{"assignments": [
+		{"type": "buy", "instrument": "EURUSD", "price": "1.1834", "quantity": "1"},
+		{"type": "sell", "instrument": "EURUSD", "agent": "trader-7", "price": "1.1840", "quantity": "2"}
+	]}`)
+
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, []string{"accepted", "accepted"}, statuses(res))
+	assert.Equal(t, 1, res.Results[0].Assignment.ID)
+	assert.Equal(t, "trader-7", res.Results[1].Assignment.Agent)
+
+	r, err := api.AssignmentStore.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, assignment.Sell, r.Type)
+}
+
+func TestBatchAllOrNothingSubmitsNothingWhenAnyAssignmentIsInvalid(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp, res := submitBatch(t, api, `{"mode": "all-or-nothing", "assignments": [
+		{"type": "buy", "instrument": "EURUSD", "price": "1.1834", "quantity": "1"},
+		{"type": "sell", "instrument": "EURUSD", "price": "1.18", "quantity": "1"},
+		{"instrument": "EURUSD", "price": "1.1834", "quantity": "1"},
+		{"type": "buy", "instrument": "GBPUSD", "price": "1.3012", "quantity": "1"},
+		{"type": "hold", "instrument": "EURUSD", "price": "1.1834", "quantity": "1"}
+	]}`)
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, []string{"rejected", "rejected", "rejected", "rejected", "rejected"}, statuses(res))
+	assert.Equal(t, errBatchAborted, res.Results[0].Error.Code)
+	assert.Equal(t, errInvalidField, res.Results[1].Error.Code)
+	assert.Equal(t, "tick", res.Results[1].Error.Fields[0].Rule)
+	assert.Equal(t, "type", res.Results[2].Error.Fields[0].Field)
+	assert.Equal(t, errNotFound, res.Results[3].Error.Code)
+	assert.Equal(t, errInvalidBody, res.Results[4].Error.Code)
+
+	records, err := api.AssignmentStore.List(assignment.Filter{})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestBatchBestEffortSubmitsValidAssignments(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp, res := submitBatch(t, api, `{"mode": "best-effort", "assignments": [
+		{"type": "buy", "instrument": "EURUSD", "price": "1.1834", "quantity": "1"},
+		{"type": "sell", "instrument": "EURUSD", "price": "1.18", "quantity": "1"},
+		{"type": "sell", "instrument": "EURUSD", "price": "1.1840", "quantity": "1"}
+	]}`)
+
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Equal(t, []string{"accepted", "rejected", "accepted"}, statuses(res))
+	assert.Equal(t, 2, res.Results[2].Assignment.ID)
+}
+
+func TestBatchRejectsEveryAssignmentWhenSubmitFails(t *testing.T) {
+	api := newInstrumentAPI()
+	api.AssignmentSubmitter = failingSubmitter{err: &event.PublishError{Topic: "EURUSD.buyer-assignment", Attempts: 1, Err: errors.New("timeout")}}
+
+	resp, res := submitBatch(t, api, `{"mode": "best-effort", "assignments": [
+		{"type": "buy", "instrument": "EURUSD", "price": "1.1834", "quantity": "1"},
+		{"type": "sell", "instrument": "EURUSD", "price": "1.18", "quantity": "1"}
+	]}`)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, errSubmitError, res.Results[0].Error.Code)
+	assert.Equal(t, errInvalidField, res.Results[1].Error.Code)
+}
+
+func TestBatchReportsAssignmentsPublishedBeforeSubmitFailed(t *testing.T) {
+	api := newInstrumentAPI()
+	exchange := api.AssignmentSubmitter.(assignment.Exchange)
+	exchange["EURUSD"].MessageQueue = failingSellerQueue{MemoryQueue: &event.MemoryQueue{}}
+
+	resp, res := submitBatch(t, api, `{"assignments": [
+		{"type": "buy", "instrument": "EURUSD", "price": "1.1834", "quantity": "1"},
+		{"type": "sell", "instrument": "EURUSD", "price": "1.1840", "quantity": "1"}
+	]}`)
+
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Equal(t, []string{"accepted", "rejected"}, statuses(res))
+	assert.Equal(t, errSubmitError, res.Results[1].Error.Code)
+
+	r, err := api.AssignmentStore.Get(res.Results[0].Assignment.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, assignment.Buy, r.Type)
+}
+
+// failingSellerQueue is an in-memory queue that fails to publish to the
+// EURUSD seller assignment topic
+type failingSellerQueue struct {
+	*event.MemoryQueue
+}
+
+func (q failingSellerQueue) PublishBatch(messages []event.Envelope) error {
+	if messages[0].Topic == event.ForInstrument("EURUSD", event.TopicSellerAssignment) {
+		return &event.PublishError{Topic: messages[0].Topic, Attempts: 1, Err: errors.New("Message too large")}
+	}
+	return q.MemoryQueue.PublishBatch(messages)
+}
+
+func TestBatchReturnsBadRequestForInvalidBatch(t *testing.T) {
+	tests := map[string]string{
+		"InvalidJSON":   `{"assignments": [}`,
+		"UnknownMode":   `{"mode": "some", "assignments": [{"type": "buy", "instrument": "EURUSD", "price": "1.1834", "quantity": "1"}]}`,
+		"NoAssignments": `{"assignments": []}`,
+	}
+
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/assignments/batch", bytes.NewBufferString(body))
+			newInstrumentAPI().batchHandler(w, r, nil)
+
+			apiErr := Error{}
+			assert.NoError(t, json.NewDecoder(w.Result().Body).Decode(&apiErr))
+			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+			assert.Equal(t, errInvalidBody, apiErr.Code)
+		})
+	}
+}
+
+type failingSubmitter struct {
+	err error
+}
+
+func (s failingSubmitter) SubmitAssignment(assignment.Assignment, assignment.Type) (assignment.Assignment, error) {
+	return assignment.Assignment{}, s.err
+}
+
+func (s failingSubmitter) SubmitAssignments(batch []assignment.Submission) []assignment.Result {
+	results := make([]assignment.Result, len(batch))
+	for i := range results {
+		results[i].Err = s.err
+	}
+	return results
+}
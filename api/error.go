@@ -2,17 +2,30 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 
+	"github.com/stevestotter/assignment-server/assignment"
 	"github.com/stevestotter/assignment-server/event"
 )
 
 const (
-	errUnexpected  = 1000
-	errSubmitError = 1001
+	errUnexpected   = 1000
+	errSubmitError  = 1001
+	errNotFound     = 1002
+	errInvalidQuery = 1003
+	errInvalidBody  = 1004
+	errInvalidField = 1005
+	errBatchAborted = 1006
+	errInvalidKey   = 1007
+	errKeyConflict  = 1008
 )
 
+// errBody is returned for a request body that couldn't be decoded
+var errBody error = errors.New("Invalid request body")
+
 // ErrorUnexpected is a detailed HTTP 500 message for unexpected errors
 func ErrorUnexpected(detail string) Error {
 	return Error{
@@ -29,6 +42,27 @@ type Error struct {
 	Detail string `json:"detail"`
 	Status int    `json:"status,string"`
 	Code   int    `json:"code,string"`
+	// Fields lists each field of the request that failed validation
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes a field of a request that failed validation, and the
+// rule it failed
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// fieldErrors is returned for a request with fields that failed validation
+type fieldErrors []FieldError
+
+func (fe fieldErrors) Error() string {
+	messages := make([]string, 0, len(fe))
+	for _, f := range fe {
+		messages = append(messages, f.Message)
+	}
+	return strings.Join(messages, ", ")
 }
 
 // WriteJSON writes a JSON representation of the error to the HTTP ResponseWriter
@@ -45,20 +79,78 @@ func (e *Error) WriteJSON(w http.ResponseWriter) error {
 }
 
 func handleError(w http.ResponseWriter, err error) {
+	apiErr := errorFor(err)
+	log.Printf("%s: %s\n", apiErr.Title, err)
+	apiErr.WriteJSON(w)
+}
+
+// errorFor returns the JSON error describing err
+func errorFor(err error) Error {
 	var apiErr Error
+	var fields fieldErrors
 
-	switch err {
-	case event.ErrQueueWrite:
+	switch {
+	case errors.Is(err, event.ErrQueueWrite):
 		apiErr = Error{
 			Title:  "Could not submit event",
 			Detail: err.Error(),
 			Status: http.StatusInternalServerError,
 			Code:   errSubmitError,
 		}
+	case errors.Is(err, assignment.ErrNotFound), errors.Is(err, assignment.ErrUnknownInstrument),
+		errors.Is(err, assignment.ErrUnknownAgent):
+		apiErr = Error{
+			Title:  "Not found",
+			Detail: err.Error(),
+			Status: http.StatusNotFound,
+			Code:   errNotFound,
+		}
+	case errors.Is(err, errQuery):
+		apiErr = Error{
+			Title:  "Invalid query",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+			Code:   errInvalidQuery,
+		}
+	case errors.Is(err, errBody):
+		apiErr = Error{
+			Title:  "Invalid request body",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+			Code:   errInvalidBody,
+		}
+	case errors.Is(err, errAborted), errors.Is(err, assignment.ErrNotSubmitted):
+		apiErr = Error{
+			Title:  "Not submitted",
+			Detail: err.Error(),
+			Status: http.StatusFailedDependency,
+			Code:   errBatchAborted,
+		}
+	case errors.Is(err, errIdempotencyKey):
+		apiErr = Error{
+			Title:  "Invalid idempotency key",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+			Code:   errInvalidKey,
+		}
+	case errors.Is(err, errIdempotencyConflict):
+		apiErr = Error{
+			Title:  "Idempotency key conflict",
+			Detail: err.Error(),
+			Status: http.StatusConflict,
+			Code:   errKeyConflict,
+		}
+	case errors.As(err, &fields):
+		apiErr = Error{
+			Title:  "Invalid fields",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+			Code:   errInvalidField,
+			Fields: fields,
+		}
 	default:
 		apiErr = ErrorUnexpected(err.Error())
 	}
 
-	log.Printf("%s: %s\n", apiErr.Title, err)
-	apiErr.WriteJSON(w)
+	return apiErr
 }
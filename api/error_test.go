@@ -1,10 +1,14 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/stevestotter/assignment-server/event"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,3 +38,71 @@ func TestWriteJSONReturnsErrorAsJSON(t *testing.T) {
 	assert.Equal(t, 404, resp.StatusCode)
 	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
 }
+
+func TestHandleErrorReturnsSubmitErrorWhenQueueWriteFails(t *testing.T) {
+	err := fmt.Errorf("Failed to publish assignment: %w",
+		&event.PublishError{Topic: event.TopicBuyerAssignment, Attempts: 5, Err: errors.New("timeout")})
+
+	w := httptest.NewRecorder()
+
+	handleError(w, err)
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	apiErr := Error{}
+	assert.NoError(t, json.Unmarshal(body, &apiErr))
+	assert.Equal(t, errSubmitError, apiErr.Code)
+	assert.Equal(t, 500, resp.StatusCode)
+}
+
+func TestHandleErrorReturnsUnexpectedErrorForOtherErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	handleError(w, errors.New("something else"))
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	apiErr := Error{}
+	assert.NoError(t, json.Unmarshal(body, &apiErr))
+	assert.Equal(t, errUnexpected, apiErr.Code)
+	assert.Equal(t, 500, resp.StatusCode)
+}
+
+func TestHandleErrorReturnsBadRequestForInvalidBody(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	handleError(w, fmt.Errorf("%w: %s", errBody, errors.New("unexpected EOF")))
+
+	resp := w.Result()
+	apiErr := Error{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&apiErr))
+	assert.Equal(t, errInvalidBody, apiErr.Code)
+	assert.Equal(t, "Invalid request body: unexpected EOF", apiErr.Detail)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestHandleErrorListsInvalidFields(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	handleError(w, fieldErrors{
+		{Field: "price", Rule: "required", Message: "price is required"},
+		{Field: "quantity", Rule: "lot", Message: "Quantity is not a multiple of the lot size 100: 150"},
+	})
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.JSONEq(t, `{
+		"title": "Invalid fields",
+		"detail": "price is required, Quantity is not a multiple of the lot size 100: 150",
+		"status": "400",
+		"code": "1005",
+		"fields": [
+			{"field": "price", "rule": "required", "message": "price is required"},
+			{"field": "quantity", "rule": "lot", "message": "Quantity is not a multiple of the lot size 100: 150"}
+		]
+	}`, string(body))
+	assert.Equal(t, 400, resp.StatusCode)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/stevestotter/assignment-server/event"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// pingTimeout is how long readiness waits for the event queue to respond
+const pingTimeout = 2 * time.Second
+
+// Readiness reports whether the server is ready for traffic, and why not if
+// it isn't
+type Readiness struct {
+	Ready         bool                `json:"ready"`
+	Draining      bool                `json:"draining,omitempty"`
+	Queue         string              `json:"queue"`
+	Subscriptions []SubscriptionReady `json:"subscriptions"`
+}
+
+// SubscriptionReady is the state of a subscription the server needs. Live is
+// whether it has a subscriber, and Joined is whether a subscriber currently
+// has partitions from its consumer group. Joined is for information only, as
+// there can be more servers than partitions.
+type SubscriptionReady struct {
+	event.Subscription
+	Live   bool `json:"live"`
+	Joined bool `json:"joined"`
+}
+
+// Drain fails readiness checks, so load balancers stop sending requests to
+// the server before it shuts down
+func (api *API) Drain() {
+	atomic.StoreInt32(&api.draining, 1)
+}
+
+func (api *API) healthHandler(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (api *API) readyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	readiness := api.readiness(r.Context())
+
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness)
+}
+
+// readiness checks that the server isn't draining, the event queue can be
+// reached, and every subscription the server needs is live
+func (api *API) readiness(ctx context.Context) Readiness {
+	readiness := Readiness{
+		Ready:         true,
+		Draining:      atomic.LoadInt32(&api.draining) == 1,
+		Queue:         "ok",
+		Subscriptions: []SubscriptionReady{},
+	}
+	if readiness.Draining {
+		readiness.Ready = false
+	}
+
+	if api.Queue == nil {
+		readiness.Queue = "unchecked"
+		return readiness
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := api.Queue.Ping(ctx); err != nil {
+		readiness.Ready = false
+		readiness.Queue = err.Error()
+	}
+
+	joined := make(map[event.Subscription]bool)
+	for _, s := range api.Queue.Subscriptions() {
+		joined[s.Subscription] = joined[s.Subscription] || s.Joined
+	}
+
+	for _, sub := range api.Subscriptions {
+		j, live := joined[sub]
+		readiness.Subscriptions = append(readiness.Subscriptions, SubscriptionReady{Subscription: sub, Live: live, Joined: j})
+		if !live {
+			readiness.Ready = false
+		}
+	}
+
+	return readiness
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stevestotter/assignment-server/event"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var tradeSubscriptions = []event.Subscription{
+	{Topic: event.TopicBuyerTrade, Group: event.GroupBuyer},
+	{Topic: event.TopicSellerTrade, Group: event.GroupSeller},
+}
+
+func checkReady(t *testing.T, api *API) (*http.Response, Readiness) {
+	w := httptest.NewRecorder()
+	api.readyHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil), nil)
+
+	readiness := Readiness{}
+	resp := w.Result()
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&readiness))
+	return resp, readiness
+}
+
+func TestHealthIsAlwaysOK(t *testing.T) {
+	api := &API{}
+	api.Drain()
+
+	w := httptest.NewRecorder()
+	api.healthHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil), nil)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestReadyOnceTradeSubscriptionsAreLive(t *testing.T) {
+	q := &event.MemoryQueue{}
+	api := &API{Queue: q, Subscriptions: tradeSubscriptions}
+
+	resp, readiness := checkReady(t, api)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.False(t, readiness.Ready)
+	assert.Equal(t, []SubscriptionReady{
+		{Subscription: tradeSubscriptions[0]},
+		{Subscription: tradeSubscriptions[1]},
+	}, readiness.Subscriptions)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, sub := range tradeSubscriptions {
+		_, err := q.Subscribe(ctx, sub.Topic, sub.Group)
+		assert.NoError(t, err)
+	}
+
+	resp, readiness = checkReady(t, api)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, readiness.Ready)
+	assert.Equal(t, "ok", readiness.Queue)
+	assert.Equal(t, []SubscriptionReady{
+		{Subscription: tradeSubscriptions[0], Live: true, Joined: true},
+		{Subscription: tradeSubscriptions[1], Live: true, Joined: true},
+	}, readiness.Subscriptions)
+}
+
+func TestNotReadyWhenQueueIsUnreachable(t *testing.T) {
+	q := &event.MemoryQueue{}
+	assert.NoError(t, q.Close())
+	api := &API{Queue: q}
+
+	resp, readiness := checkReady(t, api)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, event.ErrQueueClosed.Error(), readiness.Queue)
+}
+
+func TestNotReadyWhileDraining(t *testing.T) {
+	api := &API{Queue: &event.MemoryQueue{}}
+
+	resp, _ := checkReady(t, api)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	api.Drain()
+
+	resp, readiness := checkReady(t, api)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.True(t, readiness.Draining)
+}
+
+// unassignedQueue has live subscriptions that have no partitions, as happens
+// when there are more servers than partitions
+type unassignedQueue struct{}
+
+func (unassignedQueue) Ping(_ context.Context) error { return nil }
+
+func (unassignedQueue) Subscriptions() []event.SubscriptionStatus {
+	statuses := []event.SubscriptionStatus{}
+	for _, sub := range tradeSubscriptions {
+		statuses = append(statuses, event.SubscriptionStatus{Subscription: sub})
+	}
+	return statuses
+}
+
+func TestReadyWhenLiveSubscriptionsHaveNoPartitions(t *testing.T) {
+	api := &API{Queue: unassignedQueue{}, Subscriptions: tradeSubscriptions}
+
+	resp, readiness := checkReady(t, api)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []SubscriptionReady{
+		{Subscription: tradeSubscriptions[0], Live: true},
+		{Subscription: tradeSubscriptions[1], Live: true},
+	}, readiness.Subscriptions)
+}
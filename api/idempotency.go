@@ -0,0 +1,250 @@
+package api
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// replayedHeader marks a response that was replayed for a repeated
+	// idempotency key
+	replayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+
+	// defaultIdempotencyCapacity is how many responses are kept to replay
+	// if the API doesn't say
+	defaultIdempotencyCapacity = 10000
+	// maxIdempotentBodySize is the largest response body kept to replay
+	maxIdempotentBodySize = 64 << 10
+)
+
+var (
+	errIdempotencyKey      = errors.New("Invalid idempotency key")
+	errIdempotencyConflict = errors.New("Idempotency key conflict")
+)
+
+type idempotencyKey struct{}
+
+// withIdempotencyKey returns a context for a request made with the given
+// idempotency key
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// messageKey derives the key the i-th assignment of a request is published
+// with from the request's idempotency key, so retries are published with the
+// same key. It is empty if the request has no idempotency key.
+func messageKey(ctx context.Context, i int) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	if key == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(key + "/" + strconv.Itoa(i)))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyCache holds the responses to requests made with an idempotency
+// key, so a repeated request can be given the original response. Once it
+// holds capacity responses, the least recently used is forgotten to make room
+// for the next.
+type idempotencyCache struct {
+	// capacity is the most responses kept. Defaults to
+	// defaultIdempotencyCapacity.
+	capacity int
+
+	mu        sync.Mutex
+	responses map[string]*list.Element
+	// recent holds the responses from most to least recently used
+	recent *list.List
+}
+
+type idempotentResponse struct {
+	key string
+	// request is a hash of the request, to tell a retry from a different
+	// request that reuses its key
+	request     [sha256.Size]byte
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	// tooLarge is set when the body was too large to keep, so the response
+	// can't be replayed
+	tooLarge bool
+	expires  time.Time
+}
+
+// begin returns the response to replay for a request with key, or nil if the
+// request is new and should be handled. A request with the same key as one
+// still being handled, or as a different request, is a conflict.
+func (c *idempotencyCache) begin(key string, request [sha256.Size]byte, now time.Time) (*idempotentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.responses == nil {
+		c.responses = make(map[string]*list.Element)
+		c.recent = list.New()
+	}
+
+	e, ok := c.responses[key]
+	if ok && e.Value.(*idempotentResponse).expired(now) {
+		c.remove(e)
+		ok = false
+	}
+	if !ok {
+		c.responses[key] = c.recent.PushFront(&idempotentResponse{key: key, request: request})
+		c.evict()
+		return nil, nil
+	}
+
+	c.recent.MoveToFront(e)
+	r := e.Value.(*idempotentResponse)
+	switch {
+	case r.request != request:
+		return nil, fmt.Errorf("%w: key %q was used for a different request", errIdempotencyConflict, key)
+	case !r.done:
+		return nil, fmt.Errorf("%w: a request with key %q is still in progress", errIdempotencyConflict, key)
+	case r.tooLarge:
+		return nil, fmt.Errorf("%w: the response to key %q was too large to replay", errIdempotencyConflict, key)
+	}
+	return r, nil
+}
+
+// finish keeps the response to the request with key until it expires.
+// Server errors aren't kept, so the request can be tried again, and a body
+// larger than maxIdempotentBodySize isn't kept, so the request can't be.
+func (c *idempotencyCache) finish(key string, status int, contentType string, body []byte, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.responses[key]
+	if !ok {
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		c.remove(e)
+		return
+	}
+
+	r := e.Value.(*idempotentResponse)
+	r.done = true
+	r.status = status
+	r.contentType = contentType
+	r.body = body
+	if len(body) > maxIdempotentBodySize {
+		r.body = nil
+		r.tooLarge = true
+	}
+	r.expires = expires
+}
+
+// expired reports whether the response can no longer be replayed at now
+func (r *idempotentResponse) expired(now time.Time) bool {
+	return r.done && !now.Before(r.expires)
+}
+
+// evict forgets the least recently used responses until there are no more
+// than the cache's capacity. The caller must hold c.mu.
+func (c *idempotencyCache) evict() {
+	capacity := c.capacity
+	if capacity <= 0 {
+		capacity = defaultIdempotencyCapacity
+	}
+
+	for c.recent.Len() > capacity {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove forgets the response in e. The caller must hold c.mu.
+func (c *idempotencyCache) remove(e *list.Element) {
+	c.recent.Remove(e)
+	delete(c.responses, e.Value.(*idempotentResponse).key)
+}
+
+// recorder records the response written to it, as well as writing it on. It
+// records no more of the body than maxIdempotentBodySize, plus a byte to tell
+// that it was larger.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if room := maxIdempotentBodySize + 1 - r.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		r.body.Write(b[:room])
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent handles requests with an Idempotency-Key header once. Repeating
+// the request with the same key within api.IdempotencyWindow replays the
+// original response without handling it again.
+func (api *API) idempotent(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			handle(w, r, ps)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			handleError(w, fmt.Errorf("%w: it must be at most %d characters", errIdempotencyKey, maxIdempotencyKeyLength))
+			return
+		}
+
+		r = r.WithContext(withIdempotencyKey(r.Context(), key))
+		if api.IdempotencyWindow <= 0 {
+			handle(w, r, ps)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			handleError(w, fmt.Errorf("%w: %s", errBody, err))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		request := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		replay, err := api.idempotency.begin(key, request, time.Now())
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		if replay != nil {
+			w.Header().Set("Content-Type", replay.contentType)
+			w.Header().Set(replayedHeader, "true")
+			w.WriteHeader(replay.status)
+			w.Write(replay.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		handle(rec, r, ps)
+		api.idempotency.finish(key, rec.status, w.Header().Get("Content-Type"), rec.body.Bytes(),
+			time.Now().Add(api.IdempotencyWindow))
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/event"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func buyWithKey(api *API, key, body string) *http.Response {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/instruments/EURUSD/buy", bytes.NewBufferString(body))
+	r.Header.Set(idempotencyKeyHeader, key)
+	api.idempotent(api.buyHandler)(w, r, httprouter.Params{{Key: "symbol", Value: "EURUSD"}})
+	return w.Result()
+}
+
+func TestIdempotentReplaysResponseToRepeatedRequest(t *testing.T) {
+	api := newInstrumentAPI()
+	api.IdempotencyWindow = time.Minute
+
+	first := buyWithKey(api, "order-1", `{"price": "1.1834", "quantity": "1"}`)
+	firstBody, _ := ioutil.ReadAll(first.Body)
+	assert.Equal(t, http.StatusAccepted, first.StatusCode)
+
+	repeat := buyWithKey(api, "order-1", `{"price": "1.1834", "quantity": "1"}`)
+	repeatBody, _ := ioutil.ReadAll(repeat.Body)
+	assert.Equal(t, http.StatusAccepted, repeat.StatusCode)
+	assert.Equal(t, "true", repeat.Header.Get(replayedHeader))
+	assert.Equal(t, "application/json", repeat.Header.Get("Content-Type"))
+	assert.JSONEq(t, string(firstBody), string(repeatBody))
+
+	other := buyWithKey(api, "order-2", `{"price": "1.1834", "quantity": "1"}`)
+	assert.Equal(t, http.StatusAccepted, other.StatusCode)
+	assert.Empty(t, other.Header.Get(replayedHeader))
+
+	records, err := api.AssignmentStore.List(assignment.Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+}
+
+func TestIdempotentRejectsKeyReusedForDifferentRequest(t *testing.T) {
+	api := newInstrumentAPI()
+	api.IdempotencyWindow = time.Minute
+
+	buyWithKey(api, "order-1", `{"price": "1.1834", "quantity": "1"}`)
+	resp := buyWithKey(api, "order-1", `{"price": "1.1835", "quantity": "1"}`)
+
+	apiErr := Error{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&apiErr))
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, errKeyConflict, apiErr.Code)
+}
+
+func TestIdempotentRejectsKeyThatIsTooLong(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp := buyWithKey(api, strings.Repeat("k", maxIdempotencyKeyLength+1), `{"price": "1.1834", "quantity": "1"}`)
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestIdempotentDoesNotReplayServerErrors(t *testing.T) {
+	api := newInstrumentAPI()
+	api.IdempotencyWindow = time.Minute
+	submitter := api.AssignmentSubmitter
+	api.AssignmentSubmitter = failingSubmitter{err: &event.PublishError{Topic: "EURUSD.buyer-assignment", Attempts: 1, Err: errors.New("timeout")}}
+
+	resp := buyWithKey(api, "order-1", `{"price": "1.1834", "quantity": "1"}`)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	api.AssignmentSubmitter = submitter
+	resp = buyWithKey(api, "order-1", `{"price": "1.1834", "quantity": "1"}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(replayedHeader))
+}
+
+func TestIdempotencyCacheConflictsWhileInProgressAndForgetsAfterExpiry(t *testing.T) {
+	c := idempotencyCache{}
+	request := sha256.Sum256([]byte("request"))
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	replay, err := c.begin("key", request, now)
+	assert.Nil(t, replay)
+	assert.NoError(t, err)
+
+	_, err = c.begin("key", request, now)
+	assert.True(t, errors.Is(err, errIdempotencyConflict))
+
+	c.finish("key", http.StatusAccepted, "application/json", []byte("{}"), now.Add(time.Minute))
+
+	replay, err = c.begin("key", request, now.Add(59*time.Second))
+	assert.NoError(t, err)
+	if assert.NotNil(t, replay) {
+		assert.Equal(t, http.StatusAccepted, replay.status)
+	}
+
+	replay, err = c.begin("key", request, now.Add(time.Minute))
+	assert.Nil(t, replay)
+	assert.NoError(t, err)
+}
+
+func TestIdempotencyCacheForgetsLeastRecentlyUsedResponseWhenFull(t *testing.T) {
+	c := idempotencyCache{capacity: 2}
+	request := sha256.Sum256([]byte("request"))
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, key := range []string{"first", "second"} {
+		_, err := c.begin(key, request, now)
+		assert.NoError(t, err)
+		c.finish(key, http.StatusAccepted, "application/json", []byte("{}"), now.Add(time.Minute))
+	}
+
+	// Replaying the first response makes the second the least recently used
+	replay, err := c.begin("first", request, now)
+	assert.NoError(t, err)
+	assert.NotNil(t, replay)
+
+	replay, err = c.begin("third", request, now)
+	assert.Nil(t, replay)
+	assert.NoError(t, err)
+	assert.Len(t, c.responses, 2)
+
+	replay, err = c.begin("first", request, now)
+	assert.NoError(t, err)
+	assert.NotNil(t, replay)
+
+	replay, err = c.begin("second", request, now)
+	assert.Nil(t, replay)
+	assert.NoError(t, err)
+}
+
+func TestIdempotencyCacheDoesNotKeepLargeResponse(t *testing.T) {
+	c := idempotencyCache{}
+	request := sha256.Sum256([]byte("request"))
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := c.begin("key", request, now)
+	assert.NoError(t, err)
+	c.finish("key", http.StatusAccepted, "application/json", make([]byte, maxIdempotentBodySize+1), now.Add(time.Minute))
+
+	replay, err := c.begin("key", request, now)
+	assert.Nil(t, replay)
+	assert.True(t, errors.Is(err, errIdempotencyConflict))
+	assert.Nil(t, c.responses["key"].Value.(*idempotentResponse).body)
+}
+
+func TestRecorderRecordsBodyUpToLimit(t *testing.T) {
+	rec := &recorder{ResponseWriter: httptest.NewRecorder()}
+
+	rec.Write(make([]byte, maxIdempotentBodySize))
+	rec.Write([]byte("too large"))
+
+	assert.Equal(t, maxIdempotentBodySize+1, rec.body.Len())
+}
+
+func TestMessageKeyIsDerivedFromIdempotencyKey(t *testing.T) {
+	ctx := withIdempotencyKey(context.Background(), "order-1")
+
+	assert.Empty(t, messageKey(context.Background(), 0))
+	assert.Equal(t, messageKey(ctx, 0), messageKey(withIdempotencyKey(context.Background(), "order-1"), 0))
+	assert.NotEqual(t, messageKey(ctx, 0), messageKey(ctx, 1))
+	assert.NotEqual(t, messageKey(ctx, 0), messageKey(withIdempotencyKey(context.Background(), "order-2"), 0))
+}
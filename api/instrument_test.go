@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func newInstrumentAPI() *API {
+	validate = newValidator()
+
+	eurusd := assignment.Instrument{Symbol: "EURUSD", TickSize: decimal.MustParse("0.0001")}
+	q := &event.MemoryQueue{}
+	store := &assignment.MemoryStore{}
+	return &API{
+		AssignmentSubmitter: assignment.Exchange{
+			"EURUSD": {MessageQueue: q, Store: store, Instrument: eurusd, Agents: []string{"trader-7"}},
+		},
+		AssignmentStore: store,
+		Instruments:     map[string]assignment.Instrument{"EURUSD": eurusd},
+	}
+}
+
+func buyInstrument(api *API, symbol, body string) *http.Response {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/instruments/"+symbol+"/buy", bytes.NewBufferString(body))
+	api.buyHandler(w, r, httprouter.Params{{Key: "symbol", Value: symbol}})
+	return w.Result()
+}
+
+func TestBuyInstrumentSubmitsAssignmentToItsMarket(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp := buyInstrument(api, "EURUSD", `{"price": "1.1834", "quantity": "1"}`)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.JSONEq(t, `{"id": 1, "instrument": "EURUSD", "price": "1.1834", "quantity": "1"}`, string(body))
+}
+
+func TestBuyInstrumentValidatesAgainstItsTickSize(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp := buyInstrument(api, "EURUSD", `{"price": "1.18", "quantity": "1"}`)
+
+	apiErr := Error{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&apiErr))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, errInvalidField, apiErr.Code)
+	assert.Equal(t, []FieldError{{Field: "price", Rule: "tick", Message: "Price 1.18 must have exactly 4 decimal places"}}, apiErr.Fields)
+}
+
+func TestBuyInstrumentReturnsBadRequestForInvalidBody(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp := buyInstrument(api, "EURUSD", `{"price": "1.1a34", "quantity": "1"}`)
+
+	apiErr := Error{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&apiErr))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, errInvalidBody, apiErr.Code)
+	assert.Contains(t, apiErr.Detail, `Invalid decimal "1.1a34"`)
+}
+
+func TestBuyInstrumentReturnsNotFoundForUnknownInstrument(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp := buyInstrument(api, "GBPUSD", `{"price": "1.3012", "quantity": "1"}`)
+
+	apiErr := Error{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&apiErr))
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, errNotFound, apiErr.Code)
+}
+
+func TestBuyInstrumentTargetsAgent(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp := buyInstrument(api, "EURUSD", `{"agent": "trader-7", "price": "1.1834", "quantity": "1"}`)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.JSONEq(t, `{"id": 1, "instrument": "EURUSD", "agent": "trader-7", "price": "1.1834", "quantity": "1"}`, string(body))
+}
+
+func TestBuyInstrumentReturnsNotFoundForUnknownAgent(t *testing.T) {
+	api := newInstrumentAPI()
+
+	resp := buyInstrument(api, "EURUSD", `{"agent": "trader-8", "price": "1.1834", "quantity": "1"}`)
+
+	apiErr := Error{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&apiErr))
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, errNotFound, apiErr.Code)
+}
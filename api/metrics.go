@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stevestotter/assignment-server/assignment"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "assignment_server",
+		Name:      "assignment_requests_total",
+		Help:      "Buy and sell requests handled, by response status code.",
+	}, []string{"type", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "assignment_server",
+		Name:      "assignment_request_duration_seconds",
+		Help:      "How long buy and sell requests took to handle, by response status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type", "code"})
+
+	validationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "assignment_server",
+		Name:      "validation_failures_total",
+		Help:      "Fields of submitted assignments that failed validation, by the rule they failed.",
+	}, []string{"field", "rule"})
+)
+
+// instrumented counts and times the requests for assignments of type t
+// handled by next, by their response status
+func instrumented(t assignment.Type, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next(sw, r, ps)
+
+		code := strconv.Itoa(sw.status)
+		requests.WithLabelValues(t.String(), code).Inc()
+		requestDuration.WithLabelValues(t.String(), code).Observe(time.Since(start).Seconds())
+	}
+}
+
+// statusWriter remembers the status code written to the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
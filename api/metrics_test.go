@@ -0,0 +1,56 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stevestotter/assignment-server/assignment"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedCountsRequestsByStatusCode(t *testing.T) {
+	api := newInstrumentAPI()
+	handler := instrumented(assignment.Buy, api.buyHandler)
+	accepted := requests.WithLabelValues("buy", "202")
+	invalid := requests.WithLabelValues("buy", "400")
+	acceptedBefore, invalidBefore := testutil.ToFloat64(accepted), testutil.ToFloat64(invalid)
+
+	for _, body := range []string{`{"price": "1.1834", "quantity": "1"}`, `{"price": "1.18", "quantity": "1"}`} {
+		r := httptest.NewRequest(http.MethodPost, "/instruments/EURUSD/buy", bytes.NewBufferString(body))
+		handler(httptest.NewRecorder(), r, httprouter.Params{{Key: "symbol", Value: "EURUSD"}})
+	}
+
+	assert.Equal(t, acceptedBefore+1, testutil.ToFloat64(accepted))
+	assert.Equal(t, invalidBefore+1, testutil.ToFloat64(invalid))
+}
+
+func TestValidationFailuresAreCountedByFieldAndRule(t *testing.T) {
+	api := newInstrumentAPI()
+	tick := validationFailures.WithLabelValues("price", "tick")
+	before := testutil.ToFloat64(tick)
+
+	buyInstrument(api, "EURUSD", `{"price": "1.18", "quantity": "1"}`)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(tick))
+}
+
+func TestMetricsAreServed(t *testing.T) {
+	api := &API{Port: "0"}
+	assert.NoError(t, api.Start())
+	defer api.server.Close()
+
+	api.server.Handler.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodPost, "/sell", bytes.NewBufferString(`{"quantity": "1"}`)))
+
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `assignment_server_assignment_requests_total{code="400",type="sell"}`)
+	assert.Contains(t, w.Body.String(), `assignment_server_validation_failures_total{field="price",rule="required"}`)
+}
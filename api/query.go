@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/decimal"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+var errQuery = errors.New("Invalid query")
+
+// AssignmentList is a page of assignments. Next is the cursor to request the
+// following page with, and is empty on the last page.
+type AssignmentList struct {
+	Assignments []assignment.Record `json:"assignments"`
+	Next        string              `json:"next,omitempty"`
+}
+
+func (api *API) getAssignmentHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil || id <= 0 {
+		handleError(w, fmt.Errorf("%w: assignment ID must be a positive integer", errQuery))
+		return
+	}
+
+	record, err := api.AssignmentStore.Get(id)
+	if err != nil {
+		handleError(w, fmt.Errorf("Failed to get assignment %d: %w", id, err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record)
+}
+
+func (api *API) listAssignmentsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	// Ask for one more than the limit to know whether there's another page
+	limit := filter.Limit
+	filter.Limit++
+
+	records, err := api.AssignmentStore.List(filter)
+	if err != nil {
+		handleError(w, fmt.Errorf("Failed to list assignments: %w", err))
+		return
+	}
+
+	list := AssignmentList{Assignments: records}
+	if len(records) > limit {
+		list.Assignments = records[:limit]
+		list.Next = encodeCursor(list.Assignments[limit-1].ID)
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// parseFilter reads an assignment filter from query parameters. Types and
+// statuses can be given more than once, or comma-separated, to match any of
+// them.
+func parseFilter(q url.Values) (assignment.Filter, error) {
+	f := assignment.Filter{Limit: defaultListLimit}
+
+	f.Instruments = append(f.Instruments, splitValues(q["instrument"])...)
+
+	for _, v := range splitValues(q["type"]) {
+		var t assignment.Type
+		if err := t.UnmarshalText([]byte(v)); err != nil {
+			return f, fmt.Errorf("%w: %s", errQuery, err)
+		}
+		f.Types = append(f.Types, t)
+	}
+
+	for _, v := range splitValues(q["status"]) {
+		var s assignment.Status
+		if err := s.UnmarshalText([]byte(v)); err != nil {
+			return f, fmt.Errorf("%w: %s", errQuery, err)
+		}
+		f.Statuses = append(f.Statuses, s)
+	}
+
+	var err error
+	if f.MinPrice, err = parsePrice(q, "minPrice"); err != nil {
+		return f, err
+	}
+	if f.MaxPrice, err = parsePrice(q, "maxPrice"); err != nil {
+		return f, err
+	}
+	if f.CreatedAfter, err = parseTime(q, "createdAfter"); err != nil {
+		return f, err
+	}
+	if f.CreatedBefore, err = parseTime(q, "createdBefore"); err != nil {
+		return f, err
+	}
+
+	if v := q.Get("limit"); v != "" {
+		f.Limit, err = strconv.Atoi(v)
+		if err != nil || f.Limit <= 0 || f.Limit > maxListLimit {
+			return f, fmt.Errorf("%w: limit must be between 1 and %d", errQuery, maxListLimit)
+		}
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		if f.AfterID, err = decodeCursor(v); err != nil {
+			return f, fmt.Errorf("%w: cursor %q is not valid", errQuery, v)
+		}
+	}
+
+	return f, nil
+}
+
+func splitValues(values []string) []string {
+	split := []string{}
+	for _, v := range values {
+		split = append(split, strings.Split(v, ",")...)
+	}
+	return split
+}
+
+func parsePrice(q url.Values, name string) (*decimal.Decimal, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	price, err := decimal.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be a decimal number", errQuery, name)
+	}
+	return &price, nil
+}
+
+func parseTime(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("%w: %s must be an RFC 3339 time", errQuery, name)
+	}
+	return t, nil
+}
+
+// Cursors are opaque to clients, so how they page through assignments can
+// change without breaking them
+func encodeCursor(lastID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(lastID)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.Atoi(string(b))
+	if err != nil || id < 0 {
+		return 0, errors.New("Invalid cursor")
+	}
+	return id, nil
+}
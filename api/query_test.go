@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/decimal"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+var created = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func newQueryAPI(t *testing.T) *API {
+	store := &assignment.MemoryStore{}
+	records := []*assignment.Record{
+		{Assignment: assignment.Assignment{Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("1")}, Type: assignment.Buy, Status: assignment.Issued, CreatedAt: created},
+		{Assignment: assignment.Assignment{Price: decimal.MustParse("2.30"), Quantity: decimal.MustParse("1")}, Type: assignment.Sell, Status: assignment.Filled, CreatedAt: created.Add(time.Minute)},
+		{Assignment: assignment.Assignment{Price: decimal.MustParse("2.40"), Quantity: decimal.MustParse("1")}, Type: assignment.Buy, Status: assignment.Expired, CreatedAt: created.Add(2 * time.Minute)},
+		{Assignment: assignment.Assignment{Instrument: "EURUSD", Price: decimal.MustParse("2.50"), Quantity: decimal.MustParse("1")}, Type: assignment.Sell, Status: assignment.Issued, CreatedAt: created.Add(3 * time.Minute)},
+	}
+	for _, r := range records {
+		assert.NoError(t, store.Add(r))
+	}
+	return &API{AssignmentStore: store}
+}
+
+func listAssignments(t *testing.T, api *API, query string) (*http.Response, AssignmentList) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/assignments?"+query, nil)
+	api.listAssignmentsHandler(w, r, nil)
+
+	list := AssignmentList{}
+	resp := w.Result()
+	if resp.StatusCode == http.StatusOK {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	}
+	return resp, list
+}
+
+func ids(list AssignmentList) []int {
+	ids := []int{}
+	for _, r := range list.Assignments {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestGetAssignmentReturnsAssignment(t *testing.T) {
+	api := newQueryAPI(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/assignments/2", nil)
+	api.getAssignmentHandler(w, r, httprouter.Params{{Key: "id", Value: "2"}})
+
+	resp := w.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	expJSON := `{
+		"id": 2,
+		"price": "2.30",
+		"quantity": "1",
+		"type": "sell",
+		"status": "filled",
+		"filled": "0",
+		"createdAt": "2020-10-01T12:01:00Z"
+	}`
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.JSONEq(t, expJSON, string(body))
+}
+
+func TestGetAssignmentReturnsNotFoundForUnknownID(t *testing.T) {
+	api := newQueryAPI(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/assignments/123", nil)
+	api.getAssignmentHandler(w, r, httprouter.Params{{Key: "id", Value: "123"}})
+
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestGetAssignmentReturnsBadRequestForInvalidID(t *testing.T) {
+	api := newQueryAPI(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/assignments/abc", nil)
+	api.getAssignmentHandler(w, r, httprouter.Params{{Key: "id", Value: "abc"}})
+
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestListAssignmentsFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		ids   []int
+	}{
+		{"None", "", []int{1, 2, 3, 4}},
+		{"Type", "type=buy", []int{1, 3}},
+		{"Status", "status=issued", []int{1, 4}},
+		{"ManyStatuses", "status=filled,expired", []int{2, 3}},
+		{"RepeatedStatuses", "status=filled&status=expired", []int{2, 3}},
+		{"PriceRange", "minPrice=2.30&maxPrice=2.4", []int{2, 3}},
+		{"CreatedAfter", "createdAfter=2020-10-01T12:01:00Z", []int{3, 4}},
+		{"CreatedBefore", "createdBefore=2020-10-01T12:01:00Z", []int{1}},
+		{"Instrument", "instrument=EURUSD", []int{4}},
+		{"Combined", "type=sell&status=issued&minPrice=2.25", []int{4}},
+		{"NoMatches", "type=buy&minPrice=3", []int{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, list := listAssignments(t, newQueryAPI(t), test.query)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, test.ids, ids(list))
+			assert.Empty(t, list.Next)
+		})
+	}
+}
+
+func TestListAssignmentsPagesWithCursor(t *testing.T) {
+	api := newQueryAPI(t)
+
+	_, list := listAssignments(t, api, "limit=3")
+	assert.Equal(t, []int{1, 2, 3}, ids(list))
+	assert.NotEmpty(t, list.Next)
+
+	_, list = listAssignments(t, api, "limit=3&cursor="+list.Next)
+	assert.Equal(t, []int{4}, ids(list))
+	assert.Empty(t, list.Next)
+}
+
+func TestListAssignmentsReturnsBadRequestForInvalidQuery(t *testing.T) {
+	queries := []string{
+		"type=hold",
+		"status=unknown",
+		"minPrice=cheap",
+		"createdAfter=yesterday",
+		"limit=0",
+		"limit=100000",
+		"cursor=!!",
+	}
+
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			resp, _ := listAssignments(t, newQueryAPI(t), query)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/decimal"
+
+	validator "github.com/go-playground/validator/v10"
+)
+
+type instrumentKey struct{}
+
+// withInstrument returns a context that validates prices and quantities
+// against the given instrument's tick and lot sizes
+func withInstrument(ctx context.Context, i assignment.Instrument) context.Context {
+	return context.WithValue(ctx, instrumentKey{}, i)
+}
+
+func instrumentFrom(ctx context.Context) assignment.Instrument {
+	i, _ := ctx.Value(instrumentKey{}).(assignment.Instrument)
+	return i
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(jsonName)
+	v.RegisterCustomTypeFunc(decimalValue, decimal.Decimal{})
+	v.RegisterValidationCtx("tick", validateTick)
+	v.RegisterValidationCtx("lot", validateLot)
+	v.RegisterValidation("agent", validateAgent)
+	return v
+}
+
+// jsonName names fields by their JSON name in validation errors, so they
+// match the request clients sent
+func jsonName(f reflect.StructField) string {
+	name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// validateAssignment validates the assignment against the instrument, giving
+// fieldErrors if any of its fields are invalid
+func validateAssignment(ctx context.Context, a *assignment.Assignment, i assignment.Instrument) error {
+	ctx = withInstrument(ctx, i)
+
+	err := validate.StructCtx(ctx, a)
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return err
+	}
+
+	fields := make(fieldErrors, 0, len(invalid))
+	for _, fe := range invalid {
+		validationFailures.WithLabelValues(fe.Field(), fe.Tag()).Inc()
+		fields = append(fields, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: fieldMessage(ctx, fe),
+		})
+	}
+	return fields
+}
+
+// fieldMessage explains why a field failed validation
+func fieldMessage(ctx context.Context, fe validator.FieldError) string {
+	value := fmt.Sprint(fe.Value())
+
+	var err error
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "tick":
+		var price decimal.Decimal
+		if price, err = decimal.Parse(value); err == nil {
+			err = instrumentFrom(ctx).CheckPrice(price)
+		}
+	case "lot":
+		var quantity decimal.Decimal
+		if quantity, err = decimal.Parse(value); err == nil {
+			err = instrumentFrom(ctx).CheckQuantity(quantity)
+		}
+	case "agent":
+		err = assignment.CheckAgent(value)
+	}
+
+	if err == nil {
+		return fmt.Sprintf("%s failed the %s rule", fe.Field(), fe.Tag())
+	}
+	return err.Error()
+}
+
+// decimalValue lets decimals be validated as the strings they are written
+// as. A zero decimal is validated as missing.
+func decimalValue(v reflect.Value) interface{} {
+	d, ok := v.Interface().(decimal.Decimal)
+	if !ok || d.IsZero() {
+		return nil
+	}
+	return d.String()
+}
+
+func validateTick(ctx context.Context, fl validator.FieldLevel) bool {
+	price, err := decimal.Parse(fl.Field().String())
+	return err == nil && instrumentFrom(ctx).CheckPrice(price) == nil
+}
+
+func validateLot(ctx context.Context, fl validator.FieldLevel) bool {
+	quantity, err := decimal.Parse(fl.Field().String())
+	return err == nil && instrumentFrom(ctx).CheckQuantity(quantity) == nil
+}
+
+func validateAgent(fl validator.FieldLevel) bool {
+	return assignment.CheckAgent(fl.Field().String()) == nil
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/decimal"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatorDefaultsToPennyTicksAndAnyQuantity(t *testing.T) {
+	tests := []struct {
+		body  string
+		valid bool
+	}{
+		{`{"price": "2.24", "quantity": "0.5"}`, true},
+		{`{"price": 2.24, "quantity": 0.5}`, true},
+		{`{"price": ".24", "quantity": "3"}`, true},
+		{`{"quantity": "0.5"}`, false},
+		{`{"price": "2.24"}`, false},
+		{`{"price": "0.00", "quantity": "0.5"}`, false},
+		{`{"price": "2.2", "quantity": "0.5"}`, false},
+		{`{"price": "2.245", "quantity": "0.5"}`, false},
+		{`{"price": "-2.24", "quantity": "0.5"}`, false},
+		{`{"price": "2.24", "quantity": "-0.5"}`, false},
+		{`{"price": "2.24", "quantity": "0"}`, false},
+		{`{"agent": "trader-7", "price": "2.24", "quantity": "0.5"}`, true},
+		{`{"agent": "trader 7", "price": "2.24", "quantity": "0.5"}`, false},
+		{`{"agent": "trader.7", "price": "2.24", "quantity": "0.5"}`, false},
+	}
+
+	v := newValidator()
+	for _, test := range tests {
+		t.Run(test.body, func(t *testing.T) {
+			a := assignment.Assignment{}
+			assert.NoError(t, json.Unmarshal([]byte(test.body), &a))
+
+			err := v.Struct(&a)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidatorChecksPriceAgainstTickAndQuantityAgainstLot(t *testing.T) {
+	tests := []struct {
+		name       string
+		instrument assignment.Instrument
+		price      string
+		quantity   string
+		valid      bool
+	}{
+		{"FXTick", assignment.Instrument{TickSize: decimal.MustParse("0.0001")}, "1.2345", "1000", true},
+		{"OffFXTick", assignment.Instrument{TickSize: decimal.MustParse("0.0001")}, "1.23456", "1000", false},
+		{"NickelTick", assignment.Instrument{TickSize: decimal.MustParse("0.05")}, "2.25", "1", true},
+		{"NickelTickTooFewPlaces", assignment.Instrument{TickSize: decimal.MustParse("0.05")}, "2.3", "1", false},
+		{"OffNickelTick", assignment.Instrument{TickSize: decimal.MustParse("0.05")}, "2.24", "1", false},
+		{"WholeTick", assignment.Instrument{TickSize: decimal.MustParse("1")}, "102", "1", true},
+		{"OffWholeTick", assignment.Instrument{TickSize: decimal.MustParse("1")}, "102.5", "1", false},
+		{"BeyondPrecision", assignment.Instrument{TickSize: decimal.MustParse("1"), PricePrecision: 2}, "102.000", "1", false},
+		{"BelowPrecision", assignment.Instrument{TickSize: decimal.MustParse("1"), PricePrecision: 2}, "102", "1", false},
+		{"WithinPrecision", assignment.Instrument{TickSize: decimal.MustParse("1"), PricePrecision: 2}, "102.00", "1", true},
+		{"Lot", assignment.Instrument{LotSize: decimal.MustParse("100")}, "2.24", "300", true},
+		{"OffLot", assignment.Instrument{LotSize: decimal.MustParse("100")}, "2.24", "250", false},
+		{"FractionalLot", assignment.Instrument{LotSize: decimal.MustParse("0.25")}, "2.24", "1.75", true},
+		{"OffFractionalLot", assignment.Instrument{LotSize: decimal.MustParse("0.25")}, "2.24", "1.7", false},
+	}
+
+	v := newValidator()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := assignment.Assignment{
+				Price:    decimal.MustParse(test.price),
+				Quantity: decimal.MustParse(test.quantity),
+			}
+
+			err := v.StructCtx(withInstrument(context.Background(), test.instrument), &a)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateAssignmentListsEachInvalidField(t *testing.T) {
+	validate = newValidator()
+
+	a := assignment.Assignment{Agent: "trader 7", Price: decimal.MustParse("2.245")}
+	err := validateAssignment(context.Background(), &a, assignment.Instrument{})
+
+	var fields fieldErrors
+	if assert.True(t, errors.As(err, &fields)) {
+		assert.Equal(t, fieldErrors{
+			{Field: "agent", Rule: "agent", Message: `Invalid agent "trader 7", expected only letters, digits, - and _`},
+			{Field: "price", Rule: "tick", Message: "Price 2.245 must have exactly 2 decimal places"},
+			{Field: "quantity", Rule: "required", Message: "quantity is required"},
+		}, fields)
+	}
+}
+
+func TestValidateAssignmentExplainsOffLotQuantity(t *testing.T) {
+	validate = newValidator()
+
+	a := assignment.Assignment{Price: decimal.MustParse("2.25"), Quantity: decimal.MustParse("150")}
+	err := validateAssignment(context.Background(), &a, assignment.Instrument{LotSize: decimal.MustParse("100")})
+
+	var fields fieldErrors
+	if assert.True(t, errors.As(err, &fields)) && assert.Len(t, fields, 1) {
+		assert.Equal(t, "quantity", fields[0].Field)
+		assert.Equal(t, "lot", fields[0].Rule)
+		assert.Equal(t, "Quantity is not a multiple of the lot size 100: 150", fields[0].Message)
+	}
+}
@@ -1,22 +1,40 @@
 package assignment
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math"
 	"math/rand"
-	"strconv"
+	"sync"
+	"time"
 
+	"github.com/stevestotter/assignment-server/decimal"
 	"github.com/stevestotter/assignment-server/event"
 )
 
 //go:generate go run -mod=mod github.com/golang/mock/mockgen --build_flags=-mod=mod --source=assignment.go --destination=../mocks/assignment/assignment.go
 
-// Assignment is a directive given to agents (buy/sell) in a market
+// Assignment is a directive given to agents (buy/sell) in a market. Its ID is
+// given to it when it is submitted. Instrument is the symbol of the market it
+// is for, which is empty for the default market. Agent targets the assignment
+// at a single agent, where empty gives it to whichever agent takes it first.
 type Assignment struct {
-	Price    string `json:"price" validate:"required,currency"`
-	Quantity string `json:"quantity" validate:"required,numeric,excludes=-"`
+	ID         int             `json:"id"`
+	Instrument string          `json:"instrument,omitempty"`
+	Agent      string          `json:"agent,omitempty" validate:"omitempty,agent"`
+	Price      decimal.Decimal `json:"price" validate:"required,tick"`
+	Quantity   decimal.Decimal `json:"quantity" validate:"required,lot"`
+}
+
+// CheckAgent returns an error if agent can't be used to target an assignment
+// at it. Agent IDs are made of letters, digits, - and _.
+func CheckAgent(agent string) error {
+	if !namePattern.MatchString(agent) {
+		return fmt.Errorf("%w %q, expected only letters, digits, - and _", ErrInvalidAgent, agent)
+	}
+	return nil
 }
 
 // Type defines the type of assignment - either buy or sell
@@ -29,103 +47,502 @@ const (
 	Sell
 )
 
-// Submitter defines the ability to submit an assignment
+func (t Type) String() string {
+	switch t {
+	case Buy:
+		return "buy"
+	case Sell:
+		return "sell"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
+// MarshalText encodes the type as either buy or sell
+func (t Type) MarshalText() ([]byte, error) {
+	if t != Buy && t != Sell {
+		return nil, fmt.Errorf("Unknown type of assignment %d, expected BUY or SELL", int(t))
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes the type from either buy or sell
+func (t *Type) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "buy":
+		*t = Buy
+	case "sell":
+		*t = Sell
+	default:
+		return fmt.Errorf("Unknown type of assignment %q, expected buy or sell", text)
+	}
+	return nil
+}
+
+func opposite(t Type) Type {
+	if t == Buy {
+		return Sell
+	}
+	return Buy
+}
+
+// Submitter defines the ability to submit an assignment, or a batch of them
+// together. Submitted assignments are returned with their IDs, and a batch
+// has a result for each of its submissions.
 type Submitter interface {
-	SubmitAssignment(a Assignment, t Type) error
+	SubmitAssignment(a Assignment, t Type) (Assignment, error)
+	SubmitAssignments(batch []Submission) []Result
 }
 
-// Generator generates new assignments
-type Generator struct {
-	MessageQueue        event.ListenPublisher
-	PercentageChangeMin float64
-	PercentageChangeMax float64
+// Result is what happened to a submission in a batch. Err is why it wasn't
+// submitted, and is nil if Assignment was.
+type Result struct {
+	Assignment Assignment
+	Err        error
+}
+
+// ErrNotSubmitted is the error of a submission in a batch that wasn't tried,
+// because an earlier part of the batch couldn't be submitted
+var ErrNotSubmitted error = errors.New("Not submitted")
+
+// failed returns n results that all failed with err
+func failed(n int, err error) []Result {
+	results := make([]Result, n)
+	for i := range results {
+		results[i].Err = err
+	}
+	return results
 }
 
-func randomFloat64(min, max float64) float64 {
-	return min + (rand.Float64() * (max - min))
+// Submission is an assignment of type Type to be submitted in a batch. Key
+// is the key its message is published with, so consumers can dedupe it if it
+// is submitted more than once. If it is empty, a random key is used.
+type Submission struct {
+	Assignment Assignment
+	Type       Type
+	Key        string
+}
+
+// Generator generates new assignments in the market for its instrument
+type Generator struct {
+	MessageQueue event.ListenPublisher
+	Store        Store
+	Pricing      PricingStrategy
+	// Quantity decides the quantity of generated assignments. Nil uses the
+	// quantity quoted by the pricing strategy, which is the trade's.
+	Quantity QuantityStrategy
+	// Instrument is what the market trades. Its symbol namespaces the topics
+	// the generator uses, and it sets the tick grid prices are snapped to
+	// and the lots quantities are rounded down to.
+	Instrument Instrument
+	// Rounding is how prices from the pricing strategy are snapped to the
+	// tick grid
+	Rounding decimal.RoundingMode
+	// AssignmentTTL is how long an assignment can go untraded before it
+	// expires. Zero means assignments never expire.
+	AssignmentTTL time.Duration
+	// Bounds are the lowest and highest prices assignments are generated at
+	Bounds PriceBounds
+	// QuantityBounds are the smallest and largest quantities assignments are
+	// generated for
+	QuantityBounds QuantityBounds
+	// Agents are the IDs of the agents assignments can be targeted at. Each
+	// has its own topics, so targeting any other agent is refused rather
+	// than creating topics for it. None refuses every targeted assignment.
+	Agents []string
+	// CircuitBreaker halts generating assignments when prices move too far
+	// too quickly, publishing a market halt event. Nil never halts.
+	CircuitBreaker *CircuitBreaker
+	// Rand is the source of randomness given to the pricing strategy, so a
+	// run can be reproduced from its seed, its clock and the order its trades
+	// are processed in. See NewRand.
+	Rand *rand.Rand
+	// Clock gives the time trades arrive at, which time-based pricing
+	// strategies and the circuit breaker use. Nil uses the system clock.
+	Clock func() time.Time
+
+	mu        sync.Mutex
+	lastTrade Market
 }
 
 // GenerateFromTrades listens to trades and generates new assignments
-// based off of their trade value. This function is blocking.
-func (g *Generator) GenerateFromTrades() error {
-	buyTrades, err := g.MessageQueue.Subscribe(event.TopicBuyerTrade, event.GroupBuyer)
+// based off of their trade value. This function is blocking, and returns
+// once ctx is cancelled and both trade subscriptions have stopped.
+func (g *Generator) GenerateFromTrades(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	subs := g.Subscriptions()
+	buyTrades, err := g.MessageQueue.Subscribe(ctx, subs[0].Topic, subs[0].Group)
 	if err != nil {
 		return err
 	}
 
-	sellTrades, err := g.MessageQueue.Subscribe(event.TopicSellerTrade, event.GroupSeller)
+	sellTrades, err := g.MessageQueue.Subscribe(ctx, subs[1].Topic, subs[1].Group)
 	if err != nil {
 		return err
 	}
 
+	var wg sync.WaitGroup
+	wg.Add(1)
 	go func() {
-		for b := range buyTrades {
-			// TODO: Change logger
-			log.Printf("Got buy trade: %s", string(b))
+		defer wg.Done()
+		g.generateFromTrades(buyTrades, Sell)
+	}()
 
-			trade := &event.Trade{}
-			if err := json.Unmarshal(b, &trade); err != nil {
-				log.Printf("Error unmarshalling buy trade from queue: %s", err)
-				continue
-			}
+	if g.AssignmentTTL > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.expireAssignmentsUntilDone(ctx)
+		}()
+	}
 
-			percentIncrease := randomFloat64(g.PercentageChangeMin, g.PercentageChangeMax)
+	g.generateFromTrades(sellTrades, Buy)
 
-			err := g.submitNewAssignmentFromTrade(trade, percentIncrease, Sell)
-			if err != nil {
-				log.Printf("Error submitting new assignment: %s", err)
-				continue
-			}
-		}
-	}()
+	wg.Wait()
+	return nil
+}
 
-	for s := range sellTrades {
+// Subscriptions returns the buyer and seller trade subscriptions the
+// generator listens to trades on
+func (g *Generator) Subscriptions() []event.Subscription {
+	symbol := g.Instrument.Symbol
+	return []event.Subscription{
+		{Topic: event.ForInstrument(symbol, event.TopicBuyerTrade), Group: event.ForInstrument(symbol, event.GroupBuyer)},
+		{Topic: event.ForInstrument(symbol, event.TopicSellerTrade), Group: event.ForInstrument(symbol, event.GroupSeller)},
+	}
+}
+
+// generateFromTrades submits a new assignment of type t for each trade
+// received, priced by the generator's pricing strategy, acking each trade
+// once its assignment has been submitted. A trade made against an assignment
+// fills it. Trades that fail because the queue can't be written to for now
+// are nacked to be tried again, and those that can't be processed at all are
+// dead-lettered. While the market is halted, trades are acked without
+// generating new assignments.
+func (g *Generator) generateFromTrades(trades <-chan event.Message, t Type) {
+	for m := range trades {
 		// TODO: Change logger
-		log.Printf("Got sell trade: %s", string(s))
+		log.Printf("Got trade on %s: %s", m.Topic, string(m.Value))
 
 		trade := &event.Trade{}
-		if err := json.Unmarshal(s, &trade); err != nil {
-			log.Printf("Error unmarshalling sell trade from queue: %s", err)
+		if err := json.Unmarshal(m.Value, &trade); err != nil {
+			log.Printf("Error unmarshalling trade from queue: %s", err)
+			tradeParseErrors.WithLabelValues(m.Topic).Inc()
+			g.deadLetter(m, err)
 			continue
 		}
 
-		percentDecrease := -1 * randomFloat64(g.PercentageChangeMin, g.PercentageChangeMax)
-
-		err := g.submitNewAssignmentFromTrade(trade, percentDecrease, Buy)
+		// A trade by a buyer fills a buy assignment, and generates a sell
+		filled, err := g.fillFromTrade(trade, opposite(t), tradeKey(m))
 		if err != nil {
+			log.Printf("Error filling assignment from trade: %s", err)
+			g.deadLetter(m, err)
+			continue
+		}
+
+		err = g.submitNewAssignmentFromTrade(trade, t)
+		switch {
+		case err == nil:
+		case errors.Is(err, errMarketHalted):
+			log.Printf("Not generating new assignment: %s", err)
+		case retryLater(err):
+			log.Printf("Error submitting new assignment, trade will be tried again: %s", err)
+			if err := m.Nack(); err != nil {
+				log.Printf("Error nacking trade: %s", err)
+			}
+			continue
+		default:
 			log.Printf("Error submitting new assignment: %s", err)
+			if filled {
+				m = withoutFill(m, trade)
+			}
+			g.deadLetter(m, err)
 			continue
 		}
+
+		if err := m.Ack(); err != nil {
+			log.Printf("Error acking trade: %s", err)
+		}
 	}
+}
 
-	return nil
+// deadLetter moves a trade that couldn't be processed onto its dead-letter
+// topic. If that fails too, the trade is nacked to be tried again.
+func (g *Generator) deadLetter(m event.Message, cause error) {
+	if err := event.PublishDeadLetter(g.MessageQueue, m, cause); err != nil {
+		log.Printf("Error dead-lettering trade: %s", err)
+		if err := m.Nack(); err != nil {
+			log.Printf("Error nacking trade: %s", err)
+		}
+		return
+	}
+
+	if err := m.Ack(); err != nil {
+		log.Printf("Error acking trade: %s", err)
+	}
+}
+
+// retryLater reports whether a trade that failed with err could be processed
+// if it was delivered again, because the queue couldn't be written to for
+// now rather than because of the trade itself
+func retryLater(err error) bool {
+	return event.IsRetriable(err) || errors.Is(err, event.ErrQueueClosed)
 }
 
-func (g *Generator) submitNewAssignmentFromTrade(trade *event.Trade, percentChange float64, t Type) error {
-	floatPrice, err := strconv.ParseFloat(trade.Price, 64)
+// withoutFill returns m with its trade no longer made against an assignment.
+// This is what is dead-lettered when a trade's fill has been recorded but its
+// new assignment can't be submitted, so a redrive only generates the
+// assignment rather than filling twice.
+func withoutFill(m event.Message, trade *event.Trade) event.Message {
+	unfilled := *trade
+	unfilled.AssignmentID = 0
+	b, err := json.Marshal(unfilled)
 	if err != nil {
-		return fmt.Errorf("Failed to parse price into float: %s", err)
+		// TODO: Change logger
+		log.Printf("Error marshalling trade without its fill: %s", err)
+		return m
 	}
 
-	newPrice := floatPrice * ((100 + percentChange) / 100)
-	// normalise to 0.01 precision for currencies
-	newPrice = (math.Floor(newPrice*100 + 0.5)) / 100
+	m.Value = b
+	return m
+}
+
+func (g *Generator) submitNewAssignmentFromTrade(trade *event.Trade, t Type) error {
+	quote, err := g.quote(trade, t)
+	if err != nil {
+		return err
+	}
+
+	price := g.Bounds.clamp(g.Instrument.SnapPrice(quote.Price, g.Rounding), g.Instrument)
+	if price.Sign() <= 0 {
+		return fmt.Errorf("Pricing strategy gave invalid price %s", quote.Price)
+	}
+
+	quantity := g.Instrument.SnapQuantity(quote.Quantity)
+	if quantity.Sign() <= 0 && quote.Quantity.Sign() > 0 && g.Quantity != nil {
+		// A strategy sizing below a lot is down to its config rather than
+		// the trade, so the assignment is given the smallest size it can be
+		quantity = g.Instrument.LotSize
+	}
+	quantity = g.QuantityBounds.clamp(quantity)
+	if quantity.Sign() <= 0 {
+		return fmt.Errorf("Generated quantity %s is less than a lot", quote.Quantity)
+	}
+
+	if g.CircuitBreaker != nil {
+		now := g.now()
+		h, err := g.CircuitBreaker.observe(t, price, now)
+		if h != nil {
+			g.publishHalt(h, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
 
 	newAssignment := Assignment{
-		Price:    fmt.Sprintf("%.2f", newPrice),
-		Quantity: trade.Quantity,
+		Instrument: g.Instrument.Symbol,
+		Price:      price,
+		Quantity:   quantity,
+	}
+
+	if _, err := g.SubmitAssignment(newAssignment, t); err != nil {
+		return err
+	}
+
+	assignmentsGenerated.WithLabelValues(g.Instrument.Symbol, t.String()).Inc()
+	generatedPrices.WithLabelValues(g.Instrument.Symbol, t.String()).Observe(price.Float64())
+	return nil
+}
+
+// publishHalt tells agents the market has been halted by the circuit breaker
+func (g *Generator) publishHalt(h *halt, at time.Time) {
+	b, err := json.Marshal(event.MarketHalt{
+		Instrument: g.Instrument.Symbol,
+		Move:       h.move,
+		HaltedAt:   at,
+		ResumesAt:  h.resumesAt,
+	})
+	if err == nil {
+		err = g.MessageQueue.Publish(b, event.ForInstrument(g.Instrument.Symbol, event.TopicMarketHalt))
+	}
+	if err != nil {
+		// TODO: Change logger
+		log.Printf("Error publishing market halt: %s", err)
 	}
+}
 
-	return g.SubmitAssignment(newAssignment, t)
+// quote prices and sizes the assignment of type t generated from trade.
+// Trades are quoted one at a time, so the generator's randomness is drawn in
+// the order trades are processed, whichever subscription they arrive on.
+func (g *Generator) quote(trade *event.Trade, t Type) (Quote, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	m := g.observeTrade(trade)
+	quote, err := g.Pricing.Price(trade, t, m)
+	if err != nil {
+		return Quote{}, fmt.Errorf("Failed to price assignment: %w", err)
+	}
+
+	if g.Quantity != nil {
+		quote.Quantity, err = g.Quantity.Quantity(trade, m)
+		if err != nil {
+			return Quote{}, fmt.Errorf("Failed to size assignment: %w", err)
+		}
+	}
+	return quote, nil
+}
+
+// observeTrade returns the market as it was when trade arrived, and records
+// trade as the last one seen. The caller must hold g.mu.
+func (g *Generator) observeTrade(trade *event.Trade) Market {
+	m := g.lastTrade
+	m.Now = g.now()
+	m.Rand = g.Rand
+
+	g.lastTrade = Market{LastPrice: trade.Price, LastTradeAt: m.Now}
+	return m
 }
 
-// SubmitAssignment submits an assignment of type t to a message queue
-func (g *Generator) SubmitAssignment(a Assignment, t Type) error {
-	assignmentBytes, err := json.Marshal(a)
+// now returns the time on the generator's clock
+func (g *Generator) now() time.Time {
+	if g.Clock == nil {
+		return time.Now().UTC()
+	}
+	return g.Clock().UTC()
+}
+
+// SubmitAssignment records an assignment of type t in the store, giving it a
+// unique ID, and submits it to the message queue for the generator's market.
+// An assignment targeted at an agent is submitted to that agent's topic.
+func (g *Generator) SubmitAssignment(a Assignment, t Type) (Assignment, error) {
+	topic, err := g.topicFor(a, t)
+	if err != nil {
+		return Assignment{}, err
+	}
+
+	r := &Record{Assignment: a, Type: t, Status: Issued, CreatedAt: time.Now().UTC()}
+	if err := g.Store.Add(r); err != nil {
+		return Assignment{}, fmt.Errorf("Failed to store assignment: %w", err)
+	}
+
+	assignmentBytes, err := json.Marshal(r.Assignment)
 	if err != nil {
-		return fmt.Errorf("Failed to marshal new assignment: %s", err)
+		g.unsubmitted(r.ID, err)
+		return Assignment{}, fmt.Errorf("Failed to marshal new assignment: %s", err)
 	}
 
+	err = g.MessageQueue.Publish(assignmentBytes, topic)
+	if err != nil {
+		g.unsubmitted(r.ID, err)
+		return Assignment{}, fmt.Errorf("Failed to publish assignment: %w", err)
+	}
+
+	return r.Assignment, nil
+}
+
+// SubmitAssignments submits a batch of assignments like SubmitAssignment, but
+// publishes those for each topic together when the message queue can publish
+// batches. Topics are published one after another, so if one can't be, the
+// assignments for those before it have been submitted, and those for it and
+// the topics after it haven't. Theirs are removed from the store, unless
+// publishing them timed out, when they are kept as unconfirmed.
+func (g *Generator) SubmitAssignments(batch []Submission) []Result {
+	topics := []string{}
+	byTopic := make(map[string][]int)
+	for i, s := range batch {
+		topic, err := g.topicFor(s.Assignment, s.Type)
+		if err != nil {
+			return failed(len(batch), fmt.Errorf("Invalid assignment %d in batch: %w", i, err))
+		}
+		if _, ok := byTopic[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		byTopic[topic] = append(byTopic[topic], i)
+	}
+
+	// unsubmittedAll removes every assignment stored so far
+	ids := make([]int, 0, len(batch))
+	unsubmittedAll := func(err error) {
+		for _, id := range ids {
+			g.unsubmitted(id, err)
+		}
+	}
+
+	results := make([]Result, len(batch))
+	messages := make([]event.Envelope, len(batch))
+	now := time.Now().UTC()
+	for i, s := range batch {
+		r := &Record{Assignment: s.Assignment, Type: s.Type, Status: Issued, CreatedAt: now}
+		if err := g.Store.Add(r); err != nil {
+			unsubmittedAll(err)
+			return failed(len(batch), fmt.Errorf("Failed to store assignment: %w", err))
+		}
+		ids = append(ids, r.ID)
+
+		assignmentBytes, err := json.Marshal(r.Assignment)
+		if err != nil {
+			unsubmittedAll(err)
+			return failed(len(batch), fmt.Errorf("Failed to marshal new assignment: %s", err))
+		}
+
+		results[i].Assignment = r.Assignment
+		messages[i] = event.Envelope{Key: []byte(s.Key), Value: assignmentBytes}
+	}
+
+	var publishErr error
+	for _, topic := range topics {
+		indexes := byTopic[topic]
+		if publishErr != nil {
+			// Never published, so they're removed whatever the error was
+			err := fmt.Errorf("%w: publishing an earlier topic in the batch failed: %s", ErrNotSubmitted, publishErr)
+			for _, i := range indexes {
+				g.unsubmitted(ids[i], err)
+				results[i] = Result{Err: err}
+			}
+			continue
+		}
+
+		group := make([]event.Envelope, 0, len(indexes))
+		for _, i := range indexes {
+			m := messages[i]
+			m.Topic = topic
+			group = append(group, m)
+		}
+
+		if err := g.publishAll(group); err != nil {
+			publishErr = err
+			for _, i := range indexes {
+				g.unsubmitted(ids[i], err)
+				results[i] = Result{Err: fmt.Errorf("Failed to publish assignments: %w", err)}
+			}
+		}
+	}
+
+	return results
+}
+
+// publishAll publishes the messages as one batch if the message queue can,
+// or one after another, with random keys, if it can't
+func (g *Generator) publishAll(messages []event.Envelope) error {
+	if b, ok := g.MessageQueue.(event.BatchPublisher); ok {
+		return b.PublishBatch(messages)
+	}
+
+	for _, m := range messages {
+		if err := g.MessageQueue.Publish(m.Value, m.Topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// topicFor returns the topic an assignment of type t is published to, or an
+// error if it can't be submitted in the generator's market
+func (g *Generator) topicFor(a Assignment, t Type) (string, error) {
 	var topic string
 	switch t {
 	case Buy:
@@ -133,13 +550,53 @@ func (g *Generator) SubmitAssignment(a Assignment, t Type) error {
 	case Sell:
 		topic = event.TopicSellerAssignment
 	default:
-		return fmt.Errorf("Unknown type of assignment given, expected BUY or SELL")
+		return "", fmt.Errorf("Unknown type of assignment given, expected BUY or SELL")
 	}
 
-	err = g.MessageQueue.Publish(assignmentBytes, topic)
-	if err != nil {
-		return fmt.Errorf("Failed to publish assignment: %s", err)
+	if a.Instrument != g.Instrument.Symbol {
+		return "", fmt.Errorf("%w: %q is not traded in the %q market", ErrUnknownInstrument, a.Instrument, g.Instrument.Symbol)
+	}
+	if a.Agent != "" {
+		if err := g.checkAgent(a.Agent); err != nil {
+			return "", err
+		}
 	}
+	return event.ForAgent(a.Agent, event.ForInstrument(a.Instrument, topic)), nil
+}
 
-	return nil
+// checkAgent returns an error unless agent is one assignments can be targeted
+// at in the market
+func (g *Generator) checkAgent(agent string) error {
+	if err := CheckAgent(agent); err != nil {
+		return err
+	}
+	for _, known := range g.Agents {
+		if agent == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", ErrUnknownAgent, agent)
+}
+
+// unsubmitted deletes the record of an assignment that couldn't be submitted
+// because of err, so the store only holds assignments agents were given. If
+// publishing it timed out, the assignment may have been given anyway, so its
+// record is kept but marked unconfirmed.
+func (g *Generator) unsubmitted(id int, err error) {
+	if event.MayHaveWritten(err) {
+		err := g.Store.Update(id, func(r *Record) error {
+			r.Unconfirmed = true
+			return nil
+		})
+		if err != nil {
+			// TODO: Change logger
+			log.Printf("Error marking assignment %d unconfirmed: %s", id, err)
+		}
+		return
+	}
+
+	if err := g.Store.Remove(id); err != nil {
+		// TODO: Change logger
+		log.Printf("Error removing unsubmitted assignment %d: %s", id, err)
+	}
 }
@@ -3,12 +3,15 @@
 package assignment
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"strconv"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/stevestotter/assignment-server/decimal"
 	"github.com/stevestotter/assignment-server/event"
 
 	mock_event "github.com/stevestotter/assignment-server/mocks/event"
@@ -22,16 +25,16 @@ func TestAssignmentGeneratorGeneratesNewSellAssignmentOnBuyTrade(t *testing.T) {
 	defer ctrl.Finish()
 
 	buyTrade := []byte(`{"assignmentId": 123, "price": "2.24", "quantity": "0.5"}`)
-	tradeChan := make(chan []byte)
+	tradeChan := make(chan event.Message)
 
 	mockListenPublisher := mock_event.NewMockListenPublisher(ctrl)
 	mockListenPublisher.EXPECT().
-		Subscribe(event.TopicBuyerTrade, event.GroupBuyer).
+		Subscribe(gomock.Any(), event.TopicBuyerTrade, event.GroupBuyer).
 		Times(1).
 		Return(tradeChan, nil)
 
 	mockListenPublisher.EXPECT().
-		Subscribe(event.TopicSellerTrade, event.GroupSeller).
+		Subscribe(gomock.Any(), event.TopicSellerTrade, event.GroupSeller).
 		Times(1).
 		Return(nil, nil)
 
@@ -45,10 +48,10 @@ func TestAssignmentGeneratorGeneratesNewSellAssignmentOnBuyTrade(t *testing.T) {
 			assignment := &Assignment{}
 			err := json.Unmarshal(message, &assignment)
 			assert.NoError(t, err)
-			assert.Equal(t, "0.5", assignment.Quantity)
+			assert.Equal(t, 1, assignment.ID)
+			assert.Equal(t, "0.5", assignment.Quantity.String())
 
-			fPrice, err := strconv.ParseFloat(assignment.Price, 64)
-			assert.NoError(t, err)
+			fPrice := assignment.Price.Float64()
 			assert.Greater(t, fPrice, 2.24*1.01)
 			assert.Less(t, fPrice, 2.24*1.02)
 
@@ -57,14 +60,14 @@ func TestAssignmentGeneratorGeneratesNewSellAssignmentOnBuyTrade(t *testing.T) {
 		Return(nil)
 
 	g := Generator{
-		MessageQueue:        mockListenPublisher,
-		PercentageChangeMin: 1,
-		PercentageChangeMax: 2,
+		MessageQueue: mockListenPublisher,
+		Store:        &MemoryStore{},
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
 	}
 
-	go g.GenerateFromTrades()
+	go g.GenerateFromTrades(context.Background())
 
-	tradeChan <- buyTrade
+	tradeChan <- event.Message{Value: buyTrade}
 }
 
 func TestAssignmentGeneratorReturnsErrorWhenFailureToSubscribeToBuyTopic(t *testing.T) {
@@ -75,17 +78,17 @@ func TestAssignmentGeneratorReturnsErrorWhenFailureToSubscribeToBuyTopic(t *test
 
 	mockListenPublisher := mock_event.NewMockListenPublisher(ctrl)
 	mockListenPublisher.EXPECT().
-		Subscribe(event.TopicBuyerTrade, event.GroupBuyer).
+		Subscribe(gomock.Any(), event.TopicBuyerTrade, event.GroupBuyer).
 		Times(1).
 		Return(nil, expectedErr)
 
 	g := Generator{
-		MessageQueue:        mockListenPublisher,
-		PercentageChangeMin: 1,
-		PercentageChangeMax: 2,
+		MessageQueue: mockListenPublisher,
+		Store:        &MemoryStore{},
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
 	}
 
-	err := g.GenerateFromTrades()
+	err := g.GenerateFromTrades(context.Background())
 	assert.Equal(t, expectedErr, err)
 }
 
@@ -94,16 +97,16 @@ func TestAssignmentGeneratorGeneratesNewPurchaseAssignmentOnSellTrade(t *testing
 	defer ctrl.Finish()
 
 	sellTrade := []byte(`{"assignmentId": 123, "price": "2.24", "quantity": "0.5"}`)
-	tradeChan := make(chan []byte)
+	tradeChan := make(chan event.Message)
 
 	mockListenPublisher := mock_event.NewMockListenPublisher(ctrl)
 	mockListenPublisher.EXPECT().
-		Subscribe(event.TopicSellerTrade, event.GroupSeller).
+		Subscribe(gomock.Any(), event.TopicSellerTrade, event.GroupSeller).
 		Times(1).
 		Return(tradeChan, nil)
 
 	mockListenPublisher.EXPECT().
-		Subscribe(event.TopicBuyerTrade, event.GroupBuyer).
+		Subscribe(gomock.Any(), event.TopicBuyerTrade, event.GroupBuyer).
 		Times(1).
 		Return(nil, nil)
 
@@ -117,10 +120,10 @@ func TestAssignmentGeneratorGeneratesNewPurchaseAssignmentOnSellTrade(t *testing
 			assignment := &Assignment{}
 			err := json.Unmarshal(message, &assignment)
 			assert.NoError(t, err)
-			assert.Equal(t, "0.5", assignment.Quantity)
+			assert.Equal(t, 1, assignment.ID)
+			assert.Equal(t, "0.5", assignment.Quantity.String())
 
-			fPrice, err := strconv.ParseFloat(assignment.Price, 64)
-			assert.NoError(t, err)
+			fPrice := assignment.Price.Float64()
 			assert.Greater(t, fPrice, 2.24*0.98)
 			assert.Less(t, fPrice, 2.24*0.99)
 
@@ -129,14 +132,14 @@ func TestAssignmentGeneratorGeneratesNewPurchaseAssignmentOnSellTrade(t *testing
 		Return(nil)
 
 	g := Generator{
-		MessageQueue:        mockListenPublisher,
-		PercentageChangeMin: 1,
-		PercentageChangeMax: 2,
+		MessageQueue: mockListenPublisher,
+		Store:        &MemoryStore{},
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
 	}
 
-	go g.GenerateFromTrades()
+	go g.GenerateFromTrades(context.Background())
 
-	tradeChan <- sellTrade
+	tradeChan <- event.Message{Value: sellTrade}
 }
 
 func TestAssignmentGeneratorReturnsErrorWhenFailureToSubscribeToSellTopic(t *testing.T) {
@@ -147,21 +150,241 @@ func TestAssignmentGeneratorReturnsErrorWhenFailureToSubscribeToSellTopic(t *tes
 
 	mockListenPublisher := mock_event.NewMockListenPublisher(ctrl)
 	mockListenPublisher.EXPECT().
-		Subscribe(event.TopicSellerTrade, event.GroupSeller).
+		Subscribe(gomock.Any(), event.TopicSellerTrade, event.GroupSeller).
 		Times(1).
 		Return(nil, expectedErr)
 
 	mockListenPublisher.EXPECT().
-		Subscribe(event.TopicBuyerTrade, event.GroupBuyer).
+		Subscribe(gomock.Any(), event.TopicBuyerTrade, event.GroupBuyer).
 		Times(1).
 		Return(nil, nil)
 
 	g := Generator{
-		MessageQueue:        mockListenPublisher,
-		PercentageChangeMin: 1,
-		PercentageChangeMax: 2,
+		MessageQueue: mockListenPublisher,
+		Store:        &MemoryStore{},
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
 	}
 
-	err := g.GenerateFromTrades()
+	err := g.GenerateFromTrades(context.Background())
 	assert.Equal(t, expectedErr, err)
 }
+
+func TestAssignmentGeneratorReturnsWhenContextCancelled(t *testing.T) {
+	g := Generator{
+		MessageQueue: &event.MemoryQueue{},
+		Store:        &MemoryStore{},
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- g.GenerateFromTrades(ctx)
+	}()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for generator to return")
+	}
+}
+
+type publishedMessage struct {
+	message []byte
+	topic   string
+}
+
+// unreliableQueue is an in-memory queue that fails to publish the first
+// failures messages given to it
+type unreliableQueue struct {
+	*event.MemoryQueue
+	failures  int
+	published chan publishedMessage
+}
+
+func (q *unreliableQueue) Publish(message []byte, topic string) error {
+	if q.failures > 0 {
+		q.failures--
+		return event.ErrQueueWrite
+	}
+	q.published <- publishedMessage{message: message, topic: topic}
+	return nil
+}
+
+func newUnreliableQueue(failures int) *unreliableQueue {
+	return &unreliableQueue{
+		MemoryQueue: &event.MemoryQueue{Delivery: event.AtLeastOnce},
+		failures:    failures,
+		published:   make(chan publishedMessage),
+	}
+}
+
+func receivePublished(t *testing.T, queue *unreliableQueue) publishedMessage {
+	select {
+	case p := <-queue.published:
+		return p
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for message to be published")
+		return publishedMessage{}
+	}
+}
+
+func TestAssignmentGeneratorRedeliversTradeWhenSubmitAndDeadLetterFail(t *testing.T) {
+	queue := newUnreliableQueue(2)
+
+	g := Generator{
+		MessageQueue: queue,
+		Store:        &MemoryStore{},
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.GenerateFromTrades(ctx)
+
+	buyTrade := []byte(`{"assignmentId": 123, "price": "2.24", "quantity": "0.5"}`)
+	err := queue.MemoryQueue.Publish(buyTrade, event.TopicBuyerTrade)
+	assert.NoError(t, err)
+
+	p := receivePublished(t, queue)
+	assert.Equal(t, event.TopicSellerAssignment, p.topic)
+
+	assignment := &Assignment{}
+	err = json.Unmarshal(p.message, &assignment)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.5", assignment.Quantity.String())
+}
+
+func TestAssignmentGeneratorDeadLettersUnparseableTrade(t *testing.T) {
+	queue := newUnreliableQueue(0)
+
+	g := Generator{
+		MessageQueue: queue,
+		Store:        &MemoryStore{},
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.GenerateFromTrades(ctx)
+
+	sellTrade := []byte(`{"assignmentId": 123, "price": 2.24`)
+	err := queue.MemoryQueue.Publish(sellTrade, event.TopicSellerTrade)
+	assert.NoError(t, err)
+
+	p := receivePublished(t, queue)
+	assert.Equal(t, event.DeadLetterTopic(event.TopicSellerTrade), p.topic)
+
+	dl := event.DeadLetter{}
+	err = json.Unmarshal(p.message, &dl)
+	assert.NoError(t, err)
+	assert.Equal(t, string(sellTrade), string(dl.Payload))
+	assert.Equal(t, event.TopicSellerTrade, dl.Topic)
+	assert.NotEmpty(t, dl.Error)
+}
+
+func TestAssignmentGeneratorDeadLettersTradeWhenSubmitFails(t *testing.T) {
+	queue := newUnreliableQueue(1)
+
+	g := Generator{
+		MessageQueue: queue,
+		Store:        &MemoryStore{},
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.GenerateFromTrades(ctx)
+
+	buyTrade := []byte(`{"assignmentId": 123, "price": "2.24", "quantity": "0.5"}`)
+	err := queue.MemoryQueue.Publish(buyTrade, event.TopicBuyerTrade)
+	assert.NoError(t, err)
+
+	p := receivePublished(t, queue)
+	assert.Equal(t, event.DeadLetterTopic(event.TopicBuyerTrade), p.topic)
+
+	dl := event.DeadLetter{}
+	err = json.Unmarshal(p.message, &dl)
+	assert.NoError(t, err)
+	assert.Equal(t, string(buyTrade), string(dl.Payload))
+	assert.Contains(t, dl.Error, "Failed to publish assignment")
+}
+
+func TestAssignmentGeneratorFillsAssignmentTradeWasMadeAgainst(t *testing.T) {
+	queue := newUnreliableQueue(0)
+	store := &MemoryStore{}
+
+	g := Generator{
+		MessageQueue: queue,
+		Store:        store,
+		Pricing:      &PercentageChange{Min: 1, Max: 2},
+	}
+
+	bought := &Record{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}, Type: Buy, Status: Issued}
+	assert.NoError(t, store.Add(bought))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.GenerateFromTrades(ctx)
+
+	buyTrade := []byte(fmt.Sprintf(`{"assignmentId": %d, "price": "2.24", "quantity": "0.5"}`, bought.ID))
+	err := queue.MemoryQueue.Publish(buyTrade, event.TopicBuyerTrade)
+	assert.NoError(t, err)
+
+	p := receivePublished(t, queue)
+	assert.Equal(t, event.TopicSellerAssignment, p.topic)
+
+	r, err := store.Get(bought.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, Filled, r.Status)
+	assert.Equal(t, "0.5", r.Filled.String())
+}
+
+func TestAssignmentGeneratorSubmitAssignmentStoresAssignmentWithID(t *testing.T) {
+	queue := &event.MemoryQueue{}
+	store := &MemoryStore{}
+
+	g := Generator{
+		MessageQueue: queue,
+		Store:        store,
+	}
+
+	assignments, err := queue.Subscribe(context.Background(), event.TopicBuyerAssignment, event.GroupBuyer)
+	assert.NoError(t, err)
+
+	first, err := g.SubmitAssignment(Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}, Buy)
+	assert.NoError(t, err)
+	second, err := g.SubmitAssignment(Assignment{Price: decimal.MustParse("2.25"), Quantity: decimal.MustParse("1")}, Buy)
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first.ID, second.ID)
+
+	r, err := store.Get(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, first, r.Assignment)
+	assert.Equal(t, Buy, r.Type)
+	assert.Equal(t, Issued, r.Status)
+
+	published := &Assignment{}
+	err = json.Unmarshal((<-assignments).Value, &published)
+	assert.NoError(t, err)
+	assert.Equal(t, first, *published)
+}
+
+func TestAssignmentGeneratorSubmitAssignmentDoesNotStoreAssignmentWhenPublishFails(t *testing.T) {
+	store := &MemoryStore{}
+
+	g := Generator{
+		MessageQueue: newUnreliableQueue(1),
+		Store:        store,
+	}
+
+	_, err := g.SubmitAssignment(Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}, Sell)
+	assert.True(t, errors.Is(err, event.ErrQueueWrite))
+
+	_, err = store.Get(1)
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,266 @@
+package assignment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	assignmentsBucket = []byte("assignments")
+	createdBucket     = []byte("assignments-by-created")
+)
+
+// BoltStore is a Store kept on disk in an embedded bolt database, so its
+// records survive restarts. Records are keyed by ID in ascending order, and
+// indexed by when they were created so listing them by creation time only
+// reads the records created in that time.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens the bolt database at path, creating it if it doesn't
+// exist yet
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open assignment store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(assignmentsBucket)
+		if err != nil {
+			return err
+		}
+		if tx.Bucket(createdBucket) != nil {
+			return nil
+		}
+
+		// Index the records of a store written before there was an index
+		index, err := tx.CreateBucket(createdBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			r := Record{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			return index.Put(createdKey(r.CreatedAt, r.ID), nil)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create assignment store: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func boltKey(id int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(id))
+	return k
+}
+
+// createdPrefix orders keys by time, with the sign bit of its seconds flipped
+// so times before 1970 sort before those after
+func createdPrefix(t time.Time) []byte {
+	k := make([]byte, 12)
+	binary.BigEndian.PutUint64(k, uint64(t.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(k[8:], uint32(t.Nanosecond()))
+	return k
+}
+
+// createdKey indexes the record with the given ID by when it was created
+func createdKey(created time.Time, id int) []byte {
+	return append(createdPrefix(created), boltKey(id)...)
+}
+
+// Add writes a new record to disk, giving it the next ID in sequence
+func (s *BoltStore) Add(r *Record) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(assignmentsBucket)
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		r.ID = int(id)
+
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(boltKey(r.ID), v); err != nil {
+			return err
+		}
+		return tx.Bucket(createdBucket).Put(createdKey(r.CreatedAt, r.ID), nil)
+	})
+}
+
+// Get reads the record of the assignment with the given ID from disk
+func (s *BoltStore) Get(id int) (Record, error) {
+	r := Record{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(assignmentsBucket).Get(boltKey(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &r)
+	})
+	return r, err
+}
+
+// Update changes the record of the assignment with the given ID on disk
+func (s *BoltStore) Update(id int, update func(r *Record) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(assignmentsBucket)
+
+		v := b.Get(boltKey(id))
+		if v == nil {
+			return ErrNotFound
+		}
+
+		r := Record{}
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+
+		created := r.CreatedAt
+		if err := update(&r); err != nil {
+			return err
+		}
+		r.ID = id
+
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(boltKey(id), v); err != nil {
+			return err
+		}
+
+		if r.CreatedAt.Equal(created) {
+			return nil
+		}
+		index := tx.Bucket(createdBucket)
+		if err := index.Delete(createdKey(created, id)); err != nil {
+			return err
+		}
+		return index.Put(createdKey(r.CreatedAt, id), nil)
+	})
+}
+
+// List reads the records that match f from disk, in order of ID
+func (s *BoltStore) List(f Filter) ([]Record, error) {
+	if !f.CreatedAfter.IsZero() || !f.CreatedBefore.IsZero() {
+		return s.listByCreated(f)
+	}
+
+	records := []Record{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(assignmentsBucket).Cursor()
+		for k, v := c.Seek(boltKey(f.AfterID + 1)); k != nil && !f.full(records); k, v = c.Next() {
+			r := Record{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			if f.matches(r) {
+				records = append(records, r)
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// listByCreated reads the records that match f from disk by scanning the
+// index for those created between f's bounds on creation time
+func (s *BoltStore) listByCreated(f Filter) ([]Record, error) {
+	records := []Record{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(assignmentsBucket)
+		c := tx.Bucket(createdBucket).Cursor()
+
+		k, _ := c.First()
+		if !f.CreatedAfter.IsZero() {
+			k, _ = c.Seek(createdPrefix(f.CreatedAfter))
+		}
+
+		var before []byte
+		if !f.CreatedBefore.IsZero() {
+			before = createdPrefix(f.CreatedBefore)
+		}
+
+		for ; k != nil; k, _ = c.Next() {
+			if before != nil && bytes.Compare(k[:len(before)], before) >= 0 {
+				break
+			}
+
+			id := int(binary.BigEndian.Uint64(k[len(k)-8:]))
+			if id <= f.AfterID {
+				continue
+			}
+
+			v := b.Get(boltKey(id))
+			if v == nil {
+				continue
+			}
+			r := Record{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			if f.matches(r) {
+				records = append(records, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = records[:f.Limit]
+	}
+	return records, nil
+}
+
+// Remove deletes the record of the assignment with the given ID from disk
+func (s *BoltStore) Remove(id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(assignmentsBucket)
+
+		v := b.Get(boltKey(id))
+		if v == nil {
+			return nil
+		}
+
+		r := Record{}
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(createdBucket).Delete(createdKey(r.CreatedAt, id)); err != nil {
+			return err
+		}
+		return b.Delete(boltKey(id))
+	})
+}
+
+// Close closes the bolt database
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,111 @@
+package assignment
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/stevestotter/assignment-server/event"
+)
+
+// Exchange runs several markets side by side, with a generator for each
+// instrument keyed by its symbol
+type Exchange map[string]*Generator
+
+// SubmitAssignment submits the assignment through the generator for its
+// instrument
+func (e Exchange) SubmitAssignment(a Assignment, t Type) (Assignment, error) {
+	g, ok := e[a.Instrument]
+	if !ok {
+		return Assignment{}, fmt.Errorf("%w %q", ErrUnknownInstrument, a.Instrument)
+	}
+	return g.SubmitAssignment(a, t)
+}
+
+// SubmitAssignments submits a batch of assignments through the generators for
+// their instruments, one market after another. If part of a market's
+// assignments can't be submitted, those for the markets before it will
+// already have been, and those for the markets after it aren't tried.
+func (e Exchange) SubmitAssignments(batch []Submission) []Result {
+	symbols := []string{}
+	markets := make(map[string][]int)
+	for i, s := range batch {
+		symbol := s.Assignment.Instrument
+		if _, ok := e[symbol]; !ok {
+			return failed(len(batch), fmt.Errorf("%w %q", ErrUnknownInstrument, symbol))
+		}
+		if _, ok := markets[symbol]; !ok {
+			symbols = append(symbols, symbol)
+		}
+		markets[symbol] = append(markets[symbol], i)
+	}
+
+	results := make([]Result, len(batch))
+	stopped := false
+	for _, symbol := range symbols {
+		indexes := markets[symbol]
+		if stopped {
+			for _, i := range indexes {
+				results[i].Err = fmt.Errorf("%w: an earlier market in the batch failed", ErrNotSubmitted)
+			}
+			continue
+		}
+
+		market := make([]Submission, 0, len(indexes))
+		for _, i := range indexes {
+			market = append(market, batch[i])
+		}
+
+		for j, result := range e[symbol].SubmitAssignments(market) {
+			results[indexes[j]] = result
+			stopped = stopped || result.Err != nil
+		}
+	}
+	return results
+}
+
+// Subscriptions returns the trade subscriptions of every market, ordered by
+// symbol
+func (e Exchange) Subscriptions() []event.Subscription {
+	symbols := make([]string, 0, len(e))
+	for symbol := range e {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	subs := []event.Subscription{}
+	for _, symbol := range symbols {
+		subs = append(subs, e[symbol].Subscriptions()...)
+	}
+	return subs
+}
+
+// GenerateFromTrades generates assignments from trades in every market. It
+// blocks until ctx is cancelled and every generator has stopped, or until
+// one of them fails, which stops the rest.
+func (e Exchange) GenerateFromTrades(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for symbol, g := range e {
+		wg.Add(1)
+		go func(symbol string, g *Generator) {
+			defer wg.Done()
+			if err := g.GenerateFromTrades(ctx); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("Failed to generate assignments for %q: %w", symbol, err)
+					cancel()
+				})
+			}
+		}(symbol, g)
+	}
+
+	wg.Wait()
+	return firstErr
+}
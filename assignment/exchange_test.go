@@ -0,0 +1,313 @@
+package assignment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExchangeSubmitsAssignmentToItsInstrumentsMarket(t *testing.T) {
+	q := &event.MemoryQueue{}
+	store := &MemoryStore{}
+	e := Exchange{
+		"":       {MessageQueue: q, Store: store},
+		"EURUSD": {MessageQueue: q, Store: store, Instrument: Instrument{Symbol: "EURUSD", TickSize: decimal.MustParse("0.0001")}},
+	}
+
+	assignments, err := q.Subscribe(context.Background(), "EURUSD."+event.TopicBuyerAssignment, event.GroupBuyer)
+	assert.NoError(t, err)
+
+	a := Assignment{Instrument: "EURUSD", Price: decimal.MustParse("1.1834"), Quantity: decimal.MustParse("1")}
+	submitted, err := e.SubmitAssignment(a, Buy)
+	assert.NoError(t, err)
+
+	select {
+	case m := <-assignments:
+		published := Assignment{}
+		assert.NoError(t, json.Unmarshal(m.Value, &published))
+		assert.Equal(t, submitted, published)
+		assert.Equal(t, "EURUSD", published.Instrument)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for assignment")
+	}
+
+	r, err := store.Get(submitted.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "EURUSD", r.Instrument)
+}
+
+func TestExchangeReturnsErrorForUnknownInstrument(t *testing.T) {
+	e := Exchange{"": {MessageQueue: &event.MemoryQueue{}, Store: &MemoryStore{}}}
+
+	_, err := e.SubmitAssignment(Assignment{Instrument: "GBPUSD", Price: decimal.MustParse("1.30"), Quantity: decimal.MustParse("1")}, Sell)
+	assert.True(t, errors.Is(err, ErrUnknownInstrument))
+}
+
+func TestSubmitAssignmentDeliversTargetedAssignmentToAgentsTopic(t *testing.T) {
+	q := &event.MemoryQueue{}
+	g := &Generator{MessageQueue: q, Store: &MemoryStore{}, Instrument: Instrument{Symbol: "EURUSD"}, Agents: []string{"trader-7"}}
+
+	shared, err := q.Subscribe(context.Background(), "EURUSD."+event.TopicSellerAssignment, event.GroupSeller)
+	assert.NoError(t, err)
+	targeted, err := q.Subscribe(context.Background(), "EURUSD."+event.TopicSellerAssignment+".trader-7", event.GroupSeller)
+	assert.NoError(t, err)
+
+	a := Assignment{Instrument: "EURUSD", Agent: "trader-7", Price: decimal.MustParse("1.18"), Quantity: decimal.MustParse("1")}
+	submitted, err := g.SubmitAssignment(a, Sell)
+	assert.NoError(t, err)
+
+	select {
+	case m := <-targeted:
+		published := Assignment{}
+		assert.NoError(t, json.Unmarshal(m.Value, &published))
+		assert.Equal(t, submitted, published)
+		assert.Equal(t, "trader-7", published.Agent)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for targeted assignment")
+	}
+
+	select {
+	case m := <-shared:
+		t.Fatalf("targeted assignment was shared: %s", m.Value)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubmitAssignmentReturnsErrorForInvalidAgent(t *testing.T) {
+	g := &Generator{MessageQueue: &event.MemoryQueue{}, Store: &MemoryStore{}}
+
+	_, err := g.SubmitAssignment(Assignment{Agent: "trader.7", Price: decimal.MustParse("1.18"), Quantity: decimal.MustParse("1")}, Buy)
+	assert.True(t, errors.Is(err, ErrInvalidAgent))
+}
+
+func TestSubmitAssignmentRefusesAgentItDoesntKnowWithoutCreatingItsTopic(t *testing.T) {
+	q := &event.MemoryQueue{}
+	g := &Generator{MessageQueue: q, Store: &MemoryStore{}, Agents: []string{"trader-7"}}
+
+	_, err := g.SubmitAssignment(Assignment{Agent: "trader-8", Price: decimal.MustParse("1.18"), Quantity: decimal.MustParse("1")}, Buy)
+	assert.True(t, errors.Is(err, ErrUnknownAgent))
+
+	backlog, err := q.Backlog(context.Background(), event.ForAgent("trader-8", event.TopicBuyerAssignment), event.GroupBuyer)
+	assert.NoError(t, err)
+	assert.Empty(t, backlog)
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+// failingBatchQueue is an in-memory queue that fails to publish batches to
+// topic, or to every topic if it is empty, with err if it is set
+type failingBatchQueue struct {
+	*event.MemoryQueue
+	topic string
+	err   error
+}
+
+func (q failingBatchQueue) PublishBatch(messages []event.Envelope) error {
+	if q.topic != "" && messages[0].Topic != q.topic {
+		return q.MemoryQueue.PublishBatch(messages)
+	}
+	if q.err != nil {
+		return &event.PublishError{Topic: messages[0].Topic, Attempts: 1, Err: q.err}
+	}
+	return event.ErrQueueWrite
+}
+
+// submittedAll asserts every result in the batch was submitted, and returns
+// the submitted assignments
+func submittedAll(t *testing.T, results []Result) []Assignment {
+	submitted := []Assignment{}
+	for _, r := range results {
+		assert.NoError(t, r.Err)
+		submitted = append(submitted, r.Assignment)
+	}
+	return submitted
+}
+
+func TestExchangeSubmitsBatchAcrossMarketsInOrder(t *testing.T) {
+	q := &event.MemoryQueue{}
+	store := &MemoryStore{}
+	e := Exchange{
+		"":       {MessageQueue: q, Store: store},
+		"EURUSD": {MessageQueue: q, Store: store, Instrument: Instrument{Symbol: "EURUSD"}},
+	}
+
+	submitted := submittedAll(t, e.SubmitAssignments([]Submission{
+		{Assignment: Assignment{Instrument: "EURUSD", Price: decimal.MustParse("1.18"), Quantity: decimal.MustParse("1")}, Type: Buy},
+		{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("1")}, Type: Sell},
+		{Assignment: Assignment{Instrument: "EURUSD", Price: decimal.MustParse("1.19"), Quantity: decimal.MustParse("1")}, Type: Sell},
+	}))
+
+	if assert.Len(t, submitted, 3) {
+		assert.Equal(t, "1.18", submitted[0].Price.String())
+		assert.Equal(t, "2.24", submitted[1].Price.String())
+		assert.Equal(t, "1.19", submitted[2].Price.String())
+	}
+
+	sellers, err := q.Subscribe(context.Background(), "EURUSD."+event.TopicSellerAssignment, event.GroupSeller)
+	assert.NoError(t, err)
+	select {
+	case m := <-sellers:
+		published := Assignment{}
+		assert.NoError(t, json.Unmarshal(m.Value, &published))
+		assert.Equal(t, submitted[2], published)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for assignment")
+	}
+}
+
+func TestExchangeSubmitsNothingFromBatchWithUnknownInstrument(t *testing.T) {
+	store := &MemoryStore{}
+	e := Exchange{"": {MessageQueue: &event.MemoryQueue{}, Store: store}}
+
+	results := e.SubmitAssignments([]Submission{
+		{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("1")}, Type: Buy},
+		{Assignment: Assignment{Instrument: "GBPUSD", Price: decimal.MustParse("1.30"), Quantity: decimal.MustParse("1")}, Type: Buy},
+	})
+	for _, r := range results {
+		assert.True(t, errors.Is(r.Err, ErrUnknownInstrument))
+	}
+
+	records, err := store.List(Filter{})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestExchangeReportsMarketsSubmittedBeforeOneFails(t *testing.T) {
+	q := &event.MemoryQueue{}
+	store := &MemoryStore{}
+	e := Exchange{
+		"":       {MessageQueue: q, Store: store},
+		"EURUSD": {MessageQueue: failingBatchQueue{MemoryQueue: q}, Store: store, Instrument: Instrument{Symbol: "EURUSD"}},
+		"GBPUSD": {MessageQueue: q, Store: store, Instrument: Instrument{Symbol: "GBPUSD"}},
+	}
+
+	results := e.SubmitAssignments([]Submission{
+		{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("1")}, Type: Buy},
+		{Assignment: Assignment{Instrument: "EURUSD", Price: decimal.MustParse("1.18"), Quantity: decimal.MustParse("1")}, Type: Buy},
+		{Assignment: Assignment{Instrument: "GBPUSD", Price: decimal.MustParse("1.30"), Quantity: decimal.MustParse("1")}, Type: Buy},
+	})
+
+	if assert.Len(t, results, 3) {
+		assert.NoError(t, results[0].Err)
+		assert.True(t, errors.Is(results[1].Err, event.ErrQueueWrite))
+		assert.True(t, errors.Is(results[2].Err, ErrNotSubmitted))
+	}
+
+	records, err := store.List(Filter{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, results[0].Assignment.ID, records[0].ID)
+	}
+}
+
+func TestSubmitAssignmentsRemovesUnpublishedAssignmentsWhenPublishFails(t *testing.T) {
+	g := &Generator{MessageQueue: failingBatchQueue{MemoryQueue: &event.MemoryQueue{}}, Store: &MemoryStore{}}
+
+	results := g.SubmitAssignments([]Submission{
+		{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("1")}, Type: Buy},
+		{Assignment: Assignment{Price: decimal.MustParse("2.25"), Quantity: decimal.MustParse("1")}, Type: Sell},
+	})
+	assert.True(t, errors.Is(results[0].Err, event.ErrQueueWrite))
+	assert.True(t, errors.Is(results[1].Err, ErrNotSubmitted))
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestSubmitAssignmentsKeepsAssignmentsPublishedBeforeATopicFails(t *testing.T) {
+	q := failingBatchQueue{MemoryQueue: &event.MemoryQueue{}, topic: event.TopicSellerAssignment}
+	g := &Generator{MessageQueue: q, Store: &MemoryStore{}}
+
+	results := g.SubmitAssignments([]Submission{
+		{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("1")}, Type: Buy},
+		{Assignment: Assignment{Price: decimal.MustParse("2.25"), Quantity: decimal.MustParse("1")}, Type: Sell},
+	})
+	assert.NoError(t, results[0].Err)
+	assert.True(t, errors.Is(results[1].Err, event.ErrQueueWrite))
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, results[0].Assignment.ID, records[0].ID)
+		assert.Equal(t, Buy, records[0].Type)
+	}
+}
+
+func TestSubmitAssignmentsKeepsTopicUnconfirmedWhenPublishTimesOut(t *testing.T) {
+	q := failingBatchQueue{MemoryQueue: &event.MemoryQueue{}, err: context.DeadlineExceeded}
+	g := &Generator{MessageQueue: q, Store: &MemoryStore{}}
+
+	results := g.SubmitAssignments([]Submission{
+		{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("1")}, Type: Buy},
+		{Assignment: Assignment{Price: decimal.MustParse("2.23"), Quantity: decimal.MustParse("1")}, Type: Buy},
+		{Assignment: Assignment{Price: decimal.MustParse("2.25"), Quantity: decimal.MustParse("1")}, Type: Sell},
+	})
+	for _, r := range results {
+		assert.Error(t, r.Err)
+	}
+
+	// The sell assignment was never published, so only the buys are kept
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		for _, r := range records {
+			assert.Equal(t, Buy, r.Type)
+			assert.True(t, r.Unconfirmed)
+		}
+	}
+}
+
+// recordingBatchQueue is an in-memory queue that records the batches
+// published to it
+type recordingBatchQueue struct {
+	*event.MemoryQueue
+	batches [][]event.Envelope
+}
+
+func (q *recordingBatchQueue) PublishBatch(messages []event.Envelope) error {
+	q.batches = append(q.batches, messages)
+	return nil
+}
+
+func TestSubmitAssignmentsPublishesEachTopicWithSubmissionsKeys(t *testing.T) {
+	q := &recordingBatchQueue{MemoryQueue: &event.MemoryQueue{}}
+	g := &Generator{MessageQueue: q, Store: &MemoryStore{}}
+
+	submittedAll(t, g.SubmitAssignments([]Submission{
+		{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("1")}, Type: Buy, Key: "order-1"},
+		{Assignment: Assignment{Price: decimal.MustParse("2.25"), Quantity: decimal.MustParse("1")}, Type: Sell},
+		{Assignment: Assignment{Price: decimal.MustParse("2.23"), Quantity: decimal.MustParse("1")}, Type: Buy, Key: "order-3"},
+	}))
+
+	if assert.Len(t, q.batches, 2) && assert.Len(t, q.batches[0], 2) && assert.Len(t, q.batches[1], 1) {
+		assert.Equal(t, "order-1", string(q.batches[0][0].Key))
+		assert.Equal(t, "order-3", string(q.batches[0][1].Key))
+		assert.Equal(t, event.TopicBuyerAssignment, q.batches[0][0].Topic)
+		assert.Equal(t, event.TopicBuyerAssignment, q.batches[0][1].Topic)
+		assert.Empty(t, q.batches[1][0].Key)
+		assert.Equal(t, event.TopicSellerAssignment, q.batches[1][0].Topic)
+	}
+}
+
+func TestExchangeSubscriptionsListsEveryMarketsTradeSubscriptions(t *testing.T) {
+	e := Exchange{
+		"EURUSD": {Instrument: Instrument{Symbol: "EURUSD"}},
+		"":       {},
+	}
+
+	assert.Equal(t, []event.Subscription{
+		{Topic: "buyer-trade", Group: "buyer"},
+		{Topic: "seller-trade", Group: "seller"},
+		{Topic: "EURUSD.buyer-trade", Group: "EURUSD.buyer"},
+		{Topic: "EURUSD.seller-trade", Group: "EURUSD.seller"},
+	}, e.Subscriptions())
+}
@@ -0,0 +1,123 @@
+package assignment
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+)
+
+// errMarketHalted is returned for an assignment that wasn't generated because
+// the circuit breaker has halted the market
+var errMarketHalted error = errors.New("Market is halted")
+
+// PriceBounds are the lowest and highest prices the generator can issue
+// assignments at. Generated prices outside of them are moved onto the nearest
+// bound. A zero bound is no bound.
+type PriceBounds struct {
+	Floor   decimal.Decimal
+	Ceiling decimal.Decimal
+}
+
+// Validate checks that the bounds are on the instrument's tick grid, and that
+// the floor isn't above the ceiling
+func (b PriceBounds) Validate(i Instrument) error {
+	for _, bound := range []decimal.Decimal{b.Floor, b.Ceiling} {
+		if bound.Sign() < 0 {
+			return fmt.Errorf("Price bound %s must not be negative", bound)
+		}
+		if !bound.IsMultipleOf(i.tickSize()) {
+			return fmt.Errorf("Price bound %w %s: %s", ErrOffTick, i.tickSize(), bound)
+		}
+	}
+	if !b.Floor.IsZero() && !b.Ceiling.IsZero() && b.Floor.Cmp(b.Ceiling) > 0 {
+		return fmt.Errorf("Price floor %s must not be above the ceiling %s", b.Floor, b.Ceiling)
+	}
+	return nil
+}
+
+// clamp moves price onto the nearest bound if it is outside of them, given to
+// the instrument's price precision
+func (b PriceBounds) clamp(price decimal.Decimal, i Instrument) decimal.Decimal {
+	if !b.Floor.IsZero() && price.Cmp(b.Floor) < 0 {
+		return b.Floor.Round(i.pricePrecision(), decimal.Truncate)
+	}
+	if !b.Ceiling.IsZero() && price.Cmp(b.Ceiling) > 0 {
+		return b.Ceiling.Round(i.pricePrecision(), decimal.Truncate)
+	}
+	return price
+}
+
+// CircuitBreaker halts a market when the prices generated in it move by more
+// than Threshold percent within Window. Buy and sell prices are watched
+// separately, so the spread between them isn't mistaken for a move. While
+// halted, which lasts for CoolDown, no assignments are generated.
+type CircuitBreaker struct {
+	// Threshold is the percentage the highest price in the window can be
+	// above the lowest before the market halts
+	Threshold float64
+	Window    time.Duration
+	CoolDown  time.Duration
+
+	mu sync.Mutex
+	// prices holds the prices generated within the window for each type
+	prices      map[Type][]pricedAt
+	haltedUntil time.Time
+}
+
+type pricedAt struct {
+	price float64
+	at    time.Time
+}
+
+// halt is a trip of the circuit breaker
+type halt struct {
+	move      float64
+	resumesAt time.Time
+}
+
+// observe records a price generated at now for an assignment of type t. It
+// returns errMarketHalted if the market is halted, and a halt as well if this
+// price is the one that halted it.
+func (b *CircuitBreaker) observe(t Type, price decimal.Decimal, now time.Time) (*halt, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Before(b.haltedUntil) {
+		return nil, fmt.Errorf("%w until %s", errMarketHalted, b.haltedUntil.Format(time.RFC3339))
+	}
+
+	if b.prices == nil {
+		b.prices = make(map[Type][]pricedAt)
+	}
+	kept := b.prices[t][:0]
+	for _, p := range b.prices[t] {
+		if now.Sub(p.at) <= b.Window {
+			kept = append(kept, p)
+		}
+	}
+	prices := append(kept, pricedAt{price: price.Float64(), at: now})
+	b.prices[t] = prices
+
+	low, high := prices[0].price, prices[0].price
+	for _, p := range prices[1:] {
+		if p.price < low {
+			low = p.price
+		}
+		if p.price > high {
+			high = p.price
+		}
+	}
+
+	move := (high - low) / low * 100
+	if move <= b.Threshold {
+		return nil, nil
+	}
+
+	b.prices = nil
+	b.haltedUntil = now.Add(b.CoolDown)
+	return &halt{move: move, resumesAt: b.haltedUntil},
+		fmt.Errorf("%w: prices moved %.2f%% within %s", errMarketHalted, move, b.Window)
+}
@@ -0,0 +1,163 @@
+package assignment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceBoundsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds PriceBounds
+		valid  bool
+	}{
+		{"None", PriceBounds{}, true},
+		{"FloorAndCeiling", PriceBounds{Floor: decimal.MustParse("1.00"), Ceiling: decimal.MustParse("5")}, true},
+		{"NegativeFloor", PriceBounds{Floor: decimal.MustParse("-1")}, false},
+		{"OffTick", PriceBounds{Ceiling: decimal.MustParse("5.005")}, false},
+		{"FloorAboveCeiling", PriceBounds{Floor: decimal.MustParse("5"), Ceiling: decimal.MustParse("1")}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.bounds.Validate(Instrument{})
+			assert.Equal(t, test.valid, err == nil, "%v", err)
+		})
+	}
+}
+
+func TestPriceBoundsClampGivesBoundToPricePrecision(t *testing.T) {
+	b := PriceBounds{Floor: decimal.MustParse("1"), Ceiling: decimal.MustParse("5")}
+
+	assert.Equal(t, "1.00", b.clamp(decimal.MustParse("0.01"), Instrument{}).String())
+	assert.Equal(t, "2.24", b.clamp(decimal.MustParse("2.24"), Instrument{}).String())
+	assert.Equal(t, "5.00", b.clamp(decimal.MustParse("9.99"), Instrument{}).String())
+}
+
+func TestCircuitBreakerHaltsForCoolDownWhenPricesMoveWithinWindow(t *testing.T) {
+	b := &CircuitBreaker{Threshold: 10, Window: time.Minute, CoolDown: 5 * time.Minute}
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	h, err := b.observe(Buy, decimal.MustParse("100"), start)
+	assert.Nil(t, h)
+	assert.NoError(t, err)
+
+	h, err = b.observe(Buy, decimal.MustParse("109"), start.Add(30*time.Second))
+	assert.Nil(t, h)
+	assert.NoError(t, err)
+
+	h, err = b.observe(Buy, decimal.MustParse("111"), start.Add(45*time.Second))
+	assert.True(t, errors.Is(err, errMarketHalted))
+	if assert.NotNil(t, h) {
+		assert.InDelta(t, 11, h.move, 1e-9)
+		assert.Equal(t, start.Add(45*time.Second+5*time.Minute), h.resumesAt)
+	}
+
+	h, err = b.observe(Buy, decimal.MustParse("111"), start.Add(time.Minute))
+	assert.Nil(t, h)
+	assert.True(t, errors.Is(err, errMarketHalted))
+
+	h, err = b.observe(Buy, decimal.MustParse("150"), start.Add(6*time.Minute))
+	assert.Nil(t, h)
+	assert.NoError(t, err)
+}
+
+func TestCircuitBreakerForgetsPricesOutsideWindow(t *testing.T) {
+	b := &CircuitBreaker{Threshold: 10, Window: time.Minute, CoolDown: 5 * time.Minute}
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, price := range []string{"100", "108", "116", "124"} {
+		h, err := b.observe(Buy, decimal.MustParse(price), start.Add(time.Duration(i)*time.Minute))
+		assert.Nil(t, h)
+		assert.NoError(t, err)
+	}
+}
+
+func TestSubmitNewAssignmentFromTradeHaltsMarketAndPublishesHalt(t *testing.T) {
+	queue := &event.MemoryQueue{}
+	pricing := &fixedPricing{price: 2.00}
+	g := &Generator{
+		MessageQueue:   queue,
+		Store:          &MemoryStore{},
+		Pricing:        pricing,
+		Instrument:     Instrument{Symbol: "EURUSD"},
+		CircuitBreaker: &CircuitBreaker{Threshold: 5, Window: time.Minute, CoolDown: time.Minute},
+	}
+
+	halts, err := queue.Subscribe(context.Background(), "EURUSD."+event.TopicMarketHalt, event.GroupBuyer)
+	assert.NoError(t, err)
+
+	trade := &event.Trade{Instrument: "EURUSD", Price: decimal.MustParse("2.00"), Quantity: decimal.MustParse("1")}
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Buy))
+
+	pricing.price = 2.20
+	err = g.submitNewAssignmentFromTrade(trade, Buy)
+	assert.True(t, errors.Is(err, errMarketHalted))
+
+	select {
+	case m := <-halts:
+		halt := event.MarketHalt{}
+		assert.NoError(t, json.Unmarshal(m.Value, &halt))
+		assert.Equal(t, "EURUSD", halt.Instrument)
+		assert.InDelta(t, 10, halt.Move, 1e-9)
+		assert.Equal(t, time.Minute, halt.ResumesAt.Sub(halt.HaltedAt))
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for market halt")
+	}
+
+	pricing.price = 2.00
+	err = g.submitNewAssignmentFromTrade(trade, Buy)
+	assert.True(t, errors.Is(err, errMarketHalted))
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestSubmitNewAssignmentFromTradeKeepsPriceWithinBounds(t *testing.T) {
+	pricing := &fixedPricing{price: 0.001}
+	g := &Generator{
+		MessageQueue: &event.MemoryQueue{},
+		Store:        &MemoryStore{},
+		Pricing:      pricing,
+		Bounds:       PriceBounds{Floor: decimal.MustParse("0.50"), Ceiling: decimal.MustParse("10")},
+	}
+
+	trade := &event.Trade{Price: decimal.MustParse("2.00"), Quantity: decimal.MustParse("1")}
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Buy))
+	pricing.price = 1e9
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Sell))
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "0.50", records[0].Price.String())
+		assert.Equal(t, "10.00", records[1].Price.String())
+	}
+}
+
+func TestSubmitNewAssignmentFromTradeDoesntHaltOnSpreadBetweenBuysAndSells(t *testing.T) {
+	g := &Generator{
+		MessageQueue:   &event.MemoryQueue{},
+		Store:          &MemoryStore{},
+		Pricing:        &PercentageChange{Min: 3, Max: 3},
+		CircuitBreaker: &CircuitBreaker{Threshold: 5, Window: time.Minute, CoolDown: time.Minute},
+	}
+
+	trade := &event.Trade{Price: decimal.MustParse("2.00"), Quantity: decimal.MustParse("1")}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Buy))
+		assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Sell))
+	}
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 20)
+}
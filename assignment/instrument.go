@@ -0,0 +1,118 @@
+package assignment
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/stevestotter/assignment-server/decimal"
+)
+
+var (
+	// ErrOffTick is returned for a price that isn't on the instrument's
+	// tick grid
+	ErrOffTick error = errors.New("Price is not a multiple of the tick size")
+	// ErrOffLot is returned for a quantity that isn't a whole number of lots
+	ErrOffLot error = errors.New("Quantity is not a multiple of the lot size")
+	// ErrUnknownInstrument is returned for an assignment for an instrument
+	// that has no market
+	ErrUnknownInstrument error = errors.New("Unknown instrument")
+	// ErrInvalidAgent is returned for an assignment targeted at an agent ID
+	// that can't be used to address it
+	ErrInvalidAgent error = errors.New("Invalid agent")
+	// ErrUnknownAgent is returned for an assignment targeted at an agent the
+	// market doesn't know
+	ErrUnknownAgent error = errors.New("Unknown agent")
+)
+
+// namePattern matches symbols and agent IDs, which are used in topic names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var defaultTickSize = decimal.New(1, 2)
+
+// Instrument is what is traded in a market, and sets the increments its
+// prices and quantities move in. The zero value is the default market, priced
+// in ticks of 0.01 to 2 decimal places, and traded in any quantity.
+type Instrument struct {
+	// Symbol identifies the instrument, such as EURUSD. It is empty for the
+	// default market.
+	Symbol string
+	// TickSize is the smallest amount a price can move by, such as 0.0001
+	// for FX-style prices, 0.05 or 1. Defaults to 0.01.
+	TickSize decimal.Decimal
+	// PricePrecision is the number of decimal places prices are given to.
+	// Defaults to, and can't be less than, the decimal places of the tick.
+	PricePrecision int32
+	// LotSize is the smallest amount a quantity can move by. Zero allows
+	// any quantity.
+	LotSize decimal.Decimal
+}
+
+// Validate checks that the instrument's increments make sense together
+func (i Instrument) Validate() error {
+	if i.Symbol != "" && !namePattern.MatchString(i.Symbol) {
+		return fmt.Errorf("Symbol %q must only contain letters, digits, - and _", i.Symbol)
+	}
+	if i.TickSize.Sign() < 0 {
+		return fmt.Errorf("Tick size %s must be positive", i.TickSize)
+	}
+	if i.PricePrecision < 0 || (i.PricePrecision != 0 && i.PricePrecision < i.tickSize().Scale()) {
+		return fmt.Errorf("Price precision of %d decimal places is too few for tick size %s", i.PricePrecision, i.tickSize())
+	}
+	if i.LotSize.Sign() < 0 {
+		return fmt.Errorf("Lot size %s must not be negative", i.LotSize)
+	}
+	return nil
+}
+
+func (i Instrument) tickSize() decimal.Decimal {
+	if i.TickSize.Sign() <= 0 {
+		return defaultTickSize
+	}
+	return i.TickSize
+}
+
+func (i Instrument) pricePrecision() int32 {
+	if tick := i.tickSize().Scale(); i.PricePrecision < tick {
+		return tick
+	}
+	return i.PricePrecision
+}
+
+// SnapPrice rounds price onto the tick grid with mode, and gives it to the
+// instrument's price precision
+func (i Instrument) SnapPrice(price decimal.Decimal, mode decimal.RoundingMode) decimal.Decimal {
+	return price.RoundToIncrement(i.tickSize(), mode).Round(i.pricePrecision(), mode)
+}
+
+// SnapQuantity rounds quantity down to a whole number of lots
+func (i Instrument) SnapQuantity(quantity decimal.Decimal) decimal.Decimal {
+	return quantity.RoundToIncrement(i.LotSize, decimal.Truncate)
+}
+
+// CheckPrice returns an error if price isn't positive, isn't given to the
+// instrument's precision, or isn't on the tick grid
+func (i Instrument) CheckPrice(price decimal.Decimal) error {
+	if price.Sign() <= 0 {
+		return fmt.Errorf("Price %s must be positive", price)
+	}
+	if price.Scale() != i.pricePrecision() {
+		return fmt.Errorf("Price %s must have exactly %d decimal places", price, i.pricePrecision())
+	}
+	if !price.IsMultipleOf(i.tickSize()) {
+		return fmt.Errorf("%w %s: %s", ErrOffTick, i.tickSize(), price)
+	}
+	return nil
+}
+
+// CheckQuantity returns an error if quantity isn't positive, or isn't a
+// whole number of lots
+func (i Instrument) CheckQuantity(quantity decimal.Decimal) error {
+	if quantity.Sign() <= 0 {
+		return fmt.Errorf("Quantity %s must be positive", quantity)
+	}
+	if !quantity.IsMultipleOf(i.LotSize) {
+		return fmt.Errorf("%w %s: %s", ErrOffLot, i.LotSize, quantity)
+	}
+	return nil
+}
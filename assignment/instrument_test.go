@@ -0,0 +1,67 @@
+package assignment
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentSnapPriceToTickGrid(t *testing.T) {
+	tests := []struct {
+		name       string
+		instrument Instrument
+		price      string
+		mode       decimal.RoundingMode
+		want       string
+	}{
+		{"Default", Instrument{}, "2.2351", decimal.HalfUp, "2.24"},
+		{"DefaultPadsPrecision", Instrument{}, "2.2", decimal.HalfUp, "2.20"},
+		{"FX", Instrument{TickSize: decimal.MustParse("0.0001")}, "1.234567", decimal.HalfUp, "1.2346"},
+		{"Nickel", Instrument{TickSize: decimal.MustParse("0.05")}, "2.2351", decimal.HalfUp, "2.25"},
+		{"NickelTruncate", Instrument{TickSize: decimal.MustParse("0.05")}, "2.2351", decimal.Truncate, "2.20"},
+		{"NickelHalfEven", Instrument{TickSize: decimal.MustParse("0.05")}, "2.275", decimal.HalfEven, "2.30"},
+		{"Whole", Instrument{TickSize: decimal.MustParse("1")}, "102.5", decimal.HalfEven, "102"},
+		{"WholeToPrecision", Instrument{TickSize: decimal.MustParse("1"), PricePrecision: 2}, "102.5", decimal.HalfUp, "103.00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.instrument.SnapPrice(decimal.MustParse(test.price), test.mode)
+			assert.Equal(t, test.want, got.String())
+		})
+	}
+}
+
+func TestInstrumentSnapQuantityRoundsDownToLot(t *testing.T) {
+	assert.Equal(t, "0.123", Instrument{}.SnapQuantity(decimal.MustParse("0.123")).String())
+	assert.Equal(t, "200", Instrument{LotSize: decimal.MustParse("100")}.SnapQuantity(decimal.MustParse("299")).String())
+	assert.True(t, Instrument{LotSize: decimal.MustParse("100")}.SnapQuantity(decimal.MustParse("99")).IsZero())
+}
+
+func TestInstrumentCheckPriceAndQuantity(t *testing.T) {
+	i := Instrument{TickSize: decimal.MustParse("0.05"), LotSize: decimal.MustParse("10")}
+
+	assert.NoError(t, i.CheckPrice(decimal.MustParse("2.25")))
+	assert.NoError(t, i.CheckPrice(decimal.MustParse("2.30")))
+	assert.Error(t, i.CheckPrice(decimal.MustParse("2.3")))
+	assert.True(t, errors.Is(i.CheckPrice(decimal.MustParse("2.24")), ErrOffTick))
+	assert.Error(t, i.CheckPrice(decimal.MustParse("2.250")))
+	assert.Error(t, i.CheckPrice(decimal.MustParse("0")))
+	assert.Error(t, i.CheckPrice(decimal.MustParse("-2.25")))
+
+	assert.NoError(t, i.CheckQuantity(decimal.MustParse("30")))
+	assert.True(t, errors.Is(i.CheckQuantity(decimal.MustParse("35")), ErrOffLot))
+	assert.Error(t, i.CheckQuantity(decimal.MustParse("0")))
+	assert.Error(t, i.CheckQuantity(decimal.MustParse("-10")))
+}
+
+func TestInstrumentValidate(t *testing.T) {
+	assert.NoError(t, Instrument{}.Validate())
+	assert.NoError(t, Instrument{TickSize: decimal.MustParse("0.0001"), PricePrecision: 6}.Validate())
+	assert.Error(t, Instrument{TickSize: decimal.MustParse("-0.01")}.Validate())
+	assert.Error(t, Instrument{TickSize: decimal.MustParse("0.0001"), PricePrecision: 2}.Validate())
+	assert.Error(t, Instrument{PricePrecision: -1}.Validate())
+	assert.Error(t, Instrument{LotSize: decimal.MustParse("-1")}.Validate())
+}
@@ -0,0 +1,149 @@
+package assignment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+)
+
+var (
+	errBadFill      = errors.New("Trade can't fill assignment")
+	errNotExpirable = errors.New("Assignment can no longer expire")
+)
+
+// fillFromTrade records that the assignment the trade was made against has
+// been traded, marking it filled or partially filled. A trade that wasn't
+// made against an assignment of type t in the generator's market is ignored,
+// as is a trade with the same key as one that already filled it. An empty
+// key always fills. It returns whether the fill was recorded.
+func (g *Generator) fillFromTrade(trade *event.Trade, t Type, key string) (bool, error) {
+	if trade.AssignmentID == 0 {
+		return false, nil
+	}
+
+	err := g.Store.Update(trade.AssignmentID, func(r *Record) error {
+		if r.Instrument != g.Instrument.Symbol {
+			return fmt.Errorf("%w: trade was in the %q market, but it is for %q", errBadFill, g.Instrument.Symbol, r.Instrument)
+		}
+		if r.Type != t {
+			return fmt.Errorf("%w: trade was for a %s assignment, but it is a %s", errBadFill, t, r.Type)
+		}
+		if key != "" {
+			for _, filledBy := range r.FilledBy {
+				if filledBy == key {
+					return fmt.Errorf("%w: trade %s has already filled it", errBadFill, key)
+				}
+			}
+		}
+		if r.Status == Filled || r.Status == Expired {
+			return fmt.Errorf("%w: it is already %s", errBadFill, r.Status)
+		}
+
+		filled, cmp, err := addQuantity(r.Filled, trade.Quantity, r.Quantity)
+		if err != nil {
+			return err
+		}
+
+		r.Filled = filled
+		r.Unconfirmed = false
+		if key != "" {
+			r.FilledBy = append(r.FilledBy, key)
+		}
+		r.Status = PartiallyFilled
+		if cmp >= 0 {
+			r.Status = Filled
+		}
+		return nil
+	})
+
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrNotFound):
+		// TODO: Change logger
+		log.Printf("Ignoring fill for unknown assignment %d", trade.AssignmentID)
+		return false, nil
+	case errors.Is(err, errBadFill):
+		log.Printf("Ignoring fill for assignment %d: %s", trade.AssignmentID, err)
+		return false, nil
+	default:
+		return false, fmt.Errorf("Failed to fill assignment %d: %w", trade.AssignmentID, err)
+	}
+}
+
+// tradeKey identifies the trade in m by where it was read from, which stays
+// the same when it is delivered again
+func tradeKey(m event.Message) string {
+	return fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset)
+}
+
+// addQuantity adds traded to filled, returning the total along with how it
+// compares to the assigned quantity
+func addQuantity(filled, traded, assigned decimal.Decimal) (decimal.Decimal, int, error) {
+	if traded.Sign() <= 0 {
+		return filled, 0, fmt.Errorf("%w: invalid traded quantity %s", errBadFill, traded)
+	}
+
+	total := filled.Add(traded)
+	return total, total.Cmp(assigned), nil
+}
+
+// expireAssignments marks assignments in the generator's market that were
+// issued before now less its assignment TTL, and haven't been fully traded,
+// as expired
+func (g *Generator) expireAssignments(now time.Time) error {
+	records, err := g.Store.List(Filter{
+		Instruments:   []string{g.Instrument.Symbol},
+		Statuses:      []Status{Issued, PartiallyFilled},
+		CreatedBefore: now.Add(-g.AssignmentTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list assignments to expire: %w", err)
+	}
+
+	for _, r := range records {
+		err := g.Store.Update(r.ID, func(r *Record) error {
+			// It may have been traded since it was listed
+			if r.Status != Issued && r.Status != PartiallyFilled {
+				return errNotExpirable
+			}
+			r.Status = Expired
+			return nil
+		})
+		if err != nil && !errors.Is(err, errNotExpirable) {
+			return fmt.Errorf("Failed to expire assignment %d: %w", r.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// expireAssignmentsUntilDone periodically expires assignments until ctx is
+// cancelled. Assignments are checked ten times per TTL, but no more than once
+// a second.
+func (g *Generator) expireAssignmentsUntilDone(ctx context.Context) {
+	interval := g.AssignmentTTL / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := g.expireAssignments(now); err != nil {
+				// TODO: Change logger
+				log.Printf("Error expiring assignments: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
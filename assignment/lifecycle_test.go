@@ -0,0 +1,248 @@
+package assignment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func addIssued(t *testing.T, s Store, typ Type, quantity string, created time.Time) int {
+	r := &Record{
+		Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse(quantity)},
+		Type:       typ,
+		Status:     Issued,
+		CreatedAt:  created,
+	}
+	assert.NoError(t, s.Add(r))
+	return r.ID
+}
+
+func mustFill(t *testing.T, g *Generator, trade *event.Trade, typ Type, key string) bool {
+	filled, err := g.fillFromTrade(trade, typ, key)
+	assert.NoError(t, err)
+	return filled
+}
+
+func TestFillFromTradePartiallyThenFullyFillsAssignment(t *testing.T) {
+	g := &Generator{Store: &MemoryStore{}}
+	id := addIssued(t, g.Store, Buy, "1.5", time.Now())
+
+	mustFill(t, g, &event.Trade{AssignmentID: id, Quantity: decimal.MustParse("0.5")}, Buy, "")
+
+	r, err := g.Store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, PartiallyFilled, r.Status)
+	assert.Equal(t, "0.5", r.Filled.String())
+
+	mustFill(t, g, &event.Trade{AssignmentID: id, Quantity: decimal.MustParse("1")}, Buy, "")
+
+	r, err = g.Store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, Filled, r.Status)
+	assert.Equal(t, "1.5", r.Filled.String())
+}
+
+func TestFillFromTradeIgnoresTradesThatCantFillAssignment(t *testing.T) {
+	tests := []struct {
+		name  string
+		trade event.Trade
+		typ   Type
+	}{
+		{"NoAssignment", event.Trade{Quantity: decimal.MustParse("1")}, Buy},
+		{"UnknownAssignment", event.Trade{AssignmentID: 123, Quantity: decimal.MustParse("1")}, Buy},
+		{"WrongType", event.Trade{AssignmentID: 1, Quantity: decimal.MustParse("1")}, Sell},
+		{"NoQuantity", event.Trade{AssignmentID: 1}, Buy},
+		{"NegativeQuantity", event.Trade{AssignmentID: 1, Quantity: decimal.MustParse("-1")}, Buy},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := &Generator{Store: &MemoryStore{}}
+			id := addIssued(t, g.Store, Buy, "1", time.Now())
+
+			mustFill(t, g, &test.trade, test.typ, "")
+
+			r, err := g.Store.Get(id)
+			assert.NoError(t, err)
+			assert.Equal(t, Issued, r.Status)
+			assert.True(t, r.Filled.IsZero())
+		})
+	}
+}
+
+func TestFillFromTradeIgnoresExpiredAssignment(t *testing.T) {
+	g := &Generator{Store: &MemoryStore{}}
+	id := addIssued(t, g.Store, Sell, "1", time.Now())
+	assert.NoError(t, g.Store.Update(id, func(r *Record) error {
+		r.Status = Expired
+		return nil
+	}))
+
+	mustFill(t, g, &event.Trade{AssignmentID: id, Quantity: decimal.MustParse("1")}, Sell, "")
+
+	r, err := g.Store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, Expired, r.Status)
+}
+
+func TestExpireAssignmentsExpiresOnlyOldOpenAssignments(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	g := &Generator{Store: &MemoryStore{}, AssignmentTTL: time.Hour}
+
+	old := addIssued(t, g.Store, Buy, "1", now.Add(-2*time.Hour))
+	recent := addIssued(t, g.Store, Buy, "1", now.Add(-time.Minute))
+	oldTraded := addIssued(t, g.Store, Sell, "1", now.Add(-2*time.Hour))
+	mustFill(t, g, &event.Trade{AssignmentID: oldTraded, Quantity: decimal.MustParse("0.5")}, Sell, "")
+	oldFilled := addIssued(t, g.Store, Sell, "1", now.Add(-2*time.Hour))
+	mustFill(t, g, &event.Trade{AssignmentID: oldFilled, Quantity: decimal.MustParse("1")}, Sell, "")
+
+	assert.NoError(t, g.expireAssignments(now))
+
+	for id, want := range map[int]Status{old: Expired, recent: Issued, oldTraded: Expired, oldFilled: Filled} {
+		r, err := g.Store.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, want, r.Status, "assignment %d", id)
+	}
+}
+
+func TestFillFromTradeIgnoresAssignmentInOtherMarket(t *testing.T) {
+	g := &Generator{Store: &MemoryStore{}, Instrument: Instrument{Symbol: "EURUSD"}}
+	id := addIssued(t, g.Store, Buy, "1", time.Now())
+
+	mustFill(t, g, &event.Trade{AssignmentID: id, Instrument: "EURUSD", Quantity: decimal.MustParse("1")}, Buy, "")
+
+	r, err := g.Store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, Issued, r.Status)
+}
+
+func TestFillFromTradeFillsOnceForTradeDeliveredAgain(t *testing.T) {
+	g := &Generator{Store: &MemoryStore{}}
+	id := addIssued(t, g.Store, Buy, "3", time.Now())
+	trade := &event.Trade{AssignmentID: id, Quantity: decimal.MustParse("1")}
+
+	assert.True(t, mustFill(t, g, trade, Buy, "buyer-trade/0/7"))
+	assert.False(t, mustFill(t, g, trade, Buy, "buyer-trade/0/7"))
+	assert.True(t, mustFill(t, g, trade, Buy, "buyer-trade/0/8"))
+
+	r, err := g.Store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", r.Filled.String())
+	assert.Equal(t, []string{"buyer-trade/0/7", "buyer-trade/0/8"}, r.FilledBy)
+}
+
+// flakyQueue is an in-memory queue that fails to publish assignments with
+// err the first failures times
+type flakyQueue struct {
+	*event.MemoryQueue
+	err      error
+	failures int32
+}
+
+func (q *flakyQueue) Publish(message []byte, topic string) error {
+	if topic == event.TopicSellerAssignment && atomic.AddInt32(&q.failures, -1) >= 0 {
+		return &event.PublishError{Topic: topic, Attempts: 1, Err: q.err}
+	}
+	return q.MemoryQueue.Publish(message, topic)
+}
+
+func TestSubmitAssignmentKeepsUnconfirmedAssignmentWhenPublishTimesOut(t *testing.T) {
+	q := &flakyQueue{MemoryQueue: &event.MemoryQueue{}, err: context.DeadlineExceeded, failures: 1}
+	g := &Generator{MessageQueue: q, Store: &MemoryStore{}}
+
+	_, err := g.SubmitAssignment(Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("1")}, Sell)
+	assert.True(t, errors.Is(err, event.ErrQueueWrite))
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.True(t, records[0].Unconfirmed)
+	}
+
+	// A trade against it shows it was given to an agent after all
+	assert.True(t, mustFill(t, g, &event.Trade{AssignmentID: records[0].ID, Quantity: decimal.MustParse("1")}, Sell, ""))
+
+	r, err := g.Store.Get(records[0].ID)
+	assert.NoError(t, err)
+	assert.False(t, r.Unconfirmed)
+	assert.Equal(t, Filled, r.Status)
+}
+
+// generateFromBuyTrade runs a generator on q until it has issued a sell
+// assignment for a buy trade against a buy assignment, returning the
+// generator and the ID of the buy assignment
+func generateFromBuyTrade(t *testing.T, q event.ListenPublisher) (*Generator, int) {
+	g := &Generator{MessageQueue: q, Store: &MemoryStore{}, Pricing: &fixedPricing{price: 2.24}}
+	id := addIssued(t, g.Store, Buy, "3", time.Now())
+
+	trade, err := json.Marshal(event.Trade{AssignmentID: id, Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("1")})
+	assert.NoError(t, err)
+	assert.NoError(t, q.Publish(trade, event.TopicBuyerTrade))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.GenerateFromTrades(ctx)
+
+	assert.Eventually(t, func() bool {
+		sells, err := g.Store.List(Filter{Types: []Type{Sell}})
+		assert.NoError(t, err)
+		return len(sells) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	return g, id
+}
+
+func TestGenerateFromTradesRetriesTradeWhenQueueIsUnavailable(t *testing.T) {
+	memory := &event.MemoryQueue{Delivery: event.AtLeastOnce}
+	q := &flakyQueue{MemoryQueue: memory, err: syscall.ECONNREFUSED, failures: 1}
+
+	g, id := generateFromBuyTrade(t, q)
+
+	r, err := g.Store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", r.Filled.String())
+	assert.Equal(t, int32(-1), atomic.LoadInt32(&q.failures), "expected the trade to be tried again")
+}
+
+func TestGenerateFromTradesDeadLettersTradeWithoutItsFill(t *testing.T) {
+	memory := &event.MemoryQueue{Delivery: event.AtLeastOnce}
+	q := &flakyQueue{MemoryQueue: memory, err: errors.New("Message too large"), failures: 1}
+
+	g := &Generator{MessageQueue: q, Store: &MemoryStore{}, Pricing: &fixedPricing{price: 2.24}}
+	id := addIssued(t, g.Store, Buy, "3", time.Now())
+
+	deadLetters, err := memory.Subscribe(context.Background(), event.DeadLetterTopic(event.TopicBuyerTrade), event.GroupRedrive)
+	assert.NoError(t, err)
+
+	trade, err := json.Marshal(event.Trade{AssignmentID: id, Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("1")})
+	assert.NoError(t, err)
+	assert.NoError(t, q.Publish(trade, event.TopicBuyerTrade))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.GenerateFromTrades(ctx)
+
+	select {
+	case m := <-deadLetters:
+		dl := event.DeadLetter{}
+		assert.NoError(t, json.Unmarshal(m.Value, &dl))
+		redriven := event.Trade{}
+		assert.NoError(t, json.Unmarshal(dl.Payload, &redriven))
+		assert.Zero(t, redriven.AssignmentID)
+		assert.Equal(t, "2.20", redriven.Price.String())
+		assert.NoError(t, m.Ack())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for dead letter")
+	}
+
+	r, err := g.Store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", r.Filled.String())
+}
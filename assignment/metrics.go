@@ -0,0 +1,29 @@
+package assignment
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	assignmentsGenerated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "assignment_server",
+		Name:      "assignments_generated_total",
+		Help:      "Assignments generated from trades.",
+	}, []string{"instrument", "type"})
+
+	tradeParseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "assignment_server",
+		Name:      "trade_parse_errors_total",
+		Help:      "Trades received that couldn't be parsed.",
+	}, []string{"topic"})
+
+	// generatedPrices buckets grow by half each time, from 0.0001 up to
+	// around 40000, so they suit instruments priced in any range
+	generatedPrices = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "assignment_server",
+		Name:      "generated_price",
+		Help:      "Prices of assignments generated from trades.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 1.5, 50),
+	}, []string{"instrument", "type"})
+)
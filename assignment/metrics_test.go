@@ -0,0 +1,53 @@
+package assignment
+
+import (
+	"testing"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateFromTradesCountsTradesThatCantBeParsed(t *testing.T) {
+	g := &Generator{MessageQueue: &event.MemoryQueue{}, Store: &MemoryStore{}}
+	parseErrors := tradeParseErrors.WithLabelValues("METRICS.buyer-trade")
+	before := testutil.ToFloat64(parseErrors)
+
+	trades := make(chan event.Message, 1)
+	trades <- event.Message{Topic: "METRICS.buyer-trade", Value: []byte("not a trade")}
+	close(trades)
+	g.generateFromTrades(trades, Sell)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(parseErrors))
+}
+
+func TestSubmitNewAssignmentFromTradeRecordsGeneratedAssignment(t *testing.T) {
+	g := &Generator{
+		MessageQueue: &event.MemoryQueue{},
+		Store:        &MemoryStore{},
+		Pricing:      &fixedPricing{price: 2.24},
+		Instrument:   Instrument{Symbol: "METRICS"},
+	}
+	generated := assignmentsGenerated.WithLabelValues("METRICS", "buy")
+	prices := generatedPrices.WithLabelValues("METRICS", "buy").(prometheus.Histogram)
+	before, pricesBefore := testutil.ToFloat64(generated), histogramOf(t, prices)
+
+	trade := &event.Trade{Instrument: "METRICS", Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("1")}
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Buy))
+
+	assert.Equal(t, before+1, testutil.ToFloat64(generated))
+
+	pricesAfter := histogramOf(t, prices)
+	assert.Equal(t, pricesBefore.GetSampleCount()+1, pricesAfter.GetSampleCount())
+	assert.InDelta(t, 2.24, pricesAfter.GetSampleSum()-pricesBefore.GetSampleSum(), 1e-9)
+}
+
+func histogramOf(t *testing.T, h prometheus.Histogram) *dto.Histogram {
+	m := &dto.Metric{}
+	assert.NoError(t, h.Write(m))
+	return m.GetHistogram()
+}
@@ -0,0 +1,264 @@
+package assignment
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+)
+
+// Market is the state of the market a trade was made in, as seen by the
+// generator when the trade arrived
+type Market struct {
+	// Now is when the trade arrived
+	Now time.Time
+	// LastPrice is the price of the trade before this one, or zero if this
+	// is the first trade seen
+	LastPrice decimal.Decimal
+	// LastTradeAt is when the trade before this one arrived
+	LastTradeAt time.Time
+	// Rand is the generator's source of randomness, which strategies should
+	// draw from so a run can be reproduced from its seed. If it is nil, a
+	// source seeded from the time is used.
+	Rand *rand.Rand
+}
+
+var timeSeededRand = NewRand(time.Now().UnixNano())
+
+func (m Market) rand() *rand.Rand {
+	if m.Rand == nil {
+		return timeSeededRand
+	}
+	return m.Rand
+}
+
+// NewRand returns a source of randomness seeded with seed, which is safe to
+// use from several goroutines at once
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+// lockedSource guards a source, as sources from rand.NewSource aren't safe for
+// concurrent use
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// Quote is the price and quantity of a new assignment. The price is snapped
+// to the instrument's tick grid when the assignment is submitted.
+type Quote struct {
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+}
+
+// PricingStrategy decides the price and quantity of the assignment of type t
+// generated from a trade
+type PricingStrategy interface {
+	Price(trade *event.Trade, t Type, m Market) (Quote, error)
+}
+
+// MeanReversion prices assignments around a fair value that is pulled from the
+// trade price toward Anchor, as an Ornstein-Uhlenbeck process on the log of
+// the price. Buy assignments are priced Spread percent below the fair value,
+// and sell assignments Spread percent above it. The quantity is the same as
+// the trade's.
+type MeanReversion struct {
+	// Anchor is the fundamental value prices revert to
+	Anchor float64
+	// Speed is how quickly prices revert to the anchor per TimeUnit. After
+	// ln(2)/Speed time units, a price is expected to be half as far away.
+	Speed float64
+	// Volatility is the standard deviation of the log price per square root
+	// of a TimeUnit, so roughly the fractional change in price per unit
+	Volatility float64
+	// Spread is the percentage either side of the fair value that buy and
+	// sell assignments are priced at
+	Spread float64
+	// TimeUnit is the time that Speed and Volatility are given per, which
+	// defaults to a second. The time since the last trade is used to step
+	// the process, or one unit for the first trade.
+	TimeUnit time.Duration
+}
+
+// Price steps the fair value on from the trade price, and spreads it
+func (p *MeanReversion) Price(trade *event.Trade, t Type, m Market) (Quote, error) {
+	price, err := parsePositivePrice(trade.Price)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	dt := elapsedUnits(m, p.TimeUnit)
+	anchor := math.Log(p.Anchor)
+
+	decay := math.Exp(-p.Speed * dt)
+	stddev := p.Volatility * math.Sqrt(dt)
+	if p.Speed > 0 {
+		stddev = p.Volatility * math.Sqrt((1-decay*decay)/(2*p.Speed))
+	}
+
+	fair, err := decimal.NewFromFloat(math.Exp(anchor + (math.Log(price)-anchor)*decay + stddev*m.rand().NormFloat64()))
+	if err != nil {
+		return Quote{}, fmt.Errorf("Failed to step fair value: %w", err)
+	}
+
+	quoted, err := spread(fair, p.Spread, t)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Price: quoted, Quantity: trade.Quantity}, nil
+}
+
+// GeometricBrownianMotion prices assignments around a reference price that
+// moves with time rather than with each trade, following geometric Brownian
+// motion. Buy assignments are priced Spread percent below the reference, and
+// sell assignments Spread percent above it. The quantity is the same as the
+// trade's.
+type GeometricBrownianMotion struct {
+	// InitialPrice is the reference price to start from. If it is zero, the
+	// price of the first trade is used.
+	InitialPrice float64
+	// Drift is the expected fractional change in the reference price per
+	// TimeUnit, which trends the market up when positive and down when
+	// negative
+	Drift float64
+	// Volatility is the standard deviation of the log of the reference price
+	// per square root of a TimeUnit
+	Volatility float64
+	// Spread is the percentage either side of the reference price that buy
+	// and sell assignments are priced at
+	Spread float64
+	// TimeUnit is the time that Drift and Volatility are given per, which
+	// defaults to a second
+	TimeUnit time.Duration
+
+	mu        sync.Mutex
+	reference float64
+	at        time.Time
+}
+
+// Price moves the reference price on to the time of the trade, and spreads it
+func (p *GeometricBrownianMotion) Price(trade *event.Trade, t Type, m Market) (Quote, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.reference == 0 {
+		p.reference = p.InitialPrice
+		if p.reference <= 0 {
+			price, err := parsePositivePrice(trade.Price)
+			if err != nil {
+				return Quote{}, err
+			}
+			p.reference = price
+		}
+		p.at = m.Now
+	}
+
+	if m.Now.After(p.at) {
+		unit := p.TimeUnit
+		if unit <= 0 {
+			unit = time.Second
+		}
+		dt := float64(m.Now.Sub(p.at)) / float64(unit)
+
+		p.reference *= math.Exp((p.Drift-p.Volatility*p.Volatility/2)*dt +
+			p.Volatility*math.Sqrt(dt)*m.rand().NormFloat64())
+		p.at = m.Now
+	}
+
+	reference, err := decimal.NewFromFloat(p.reference)
+	if err != nil {
+		return Quote{}, fmt.Errorf("Failed to move reference price: %w", err)
+	}
+
+	quoted, err := spread(reference, p.Spread, t)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Price: quoted, Quantity: trade.Quantity}, nil
+}
+
+// PercentageChange prices buy assignments below the trade price, and sell
+// assignments above it, by a uniformly random percentage between Min and Max.
+// The quantity is the same as the trade's.
+type PercentageChange struct {
+	Min float64
+	Max float64
+}
+
+// Price moves the trade price by a random percentage. The trade price is
+// moved in decimal, so it keeps its precision however large it is.
+func (p *PercentageChange) Price(trade *event.Trade, t Type, m Market) (Quote, error) {
+	if _, err := parsePositivePrice(trade.Price); err != nil {
+		return Quote{}, err
+	}
+
+	quoted, err := spread(trade.Price, randomFloat64(m.rand(), p.Min, p.Max), t)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Price: quoted, Quantity: trade.Quantity}, nil
+}
+
+func parsePositivePrice(price decimal.Decimal) (float64, error) {
+	if price.Sign() <= 0 {
+		return 0, fmt.Errorf("Price %s is not positive", price)
+	}
+	return price.Float64(), nil
+}
+
+// elapsedUnits returns how many units of time passed between the last trade
+// and this one, or one if this is the first trade. It is never negative, even
+// if the clock has stepped back since the last trade.
+func elapsedUnits(m Market, unit time.Duration) float64 {
+	if unit <= 0 {
+		unit = time.Second
+	}
+	if m.LastTradeAt.IsZero() {
+		return 1
+	}
+	if !m.Now.After(m.LastTradeAt) {
+		return 0
+	}
+	return float64(m.Now.Sub(m.LastTradeAt)) / float64(unit)
+}
+
+// spread moves a price percent down for buy assignments, or percent up for
+// sell assignments
+func spread(price decimal.Decimal, percent float64, t Type) (decimal.Decimal, error) {
+	if t == Buy {
+		percent *= -1
+	}
+
+	factor, err := decimal.NewFromFloat((100 + percent) / 100)
+	if err != nil {
+		return decimal.Decimal{}, fmt.Errorf("Invalid spread of %v percent: %w", percent, err)
+	}
+	return price.Mul(factor), nil
+}
+
+func randomFloat64(r *rand.Rand, min, max float64) float64 {
+	return min + (r.Float64() * (max - min))
+}
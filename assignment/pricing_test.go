@@ -0,0 +1,426 @@
+package assignment
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentageChangePricesBuyBelowAndSellAboveTrade(t *testing.T) {
+	p := &PercentageChange{Min: 1, Max: 2}
+	trade := &event.Trade{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}
+
+	for i := 0; i < 100; i++ {
+		buy, err := p.Price(trade, Buy, Market{})
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, buy.Price.Float64(), 2.24*0.98)
+		assert.LessOrEqual(t, buy.Price.Float64(), 2.24*0.99)
+		assert.Equal(t, "0.5", buy.Quantity.String())
+
+		sell, err := p.Price(trade, Sell, Market{})
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, sell.Price.Float64(), 2.24*1.01)
+		assert.LessOrEqual(t, sell.Price.Float64(), 2.24*1.02)
+		assert.Equal(t, "0.5", sell.Quantity.String())
+	}
+}
+
+func TestPercentageChangeKeepsPrecisionOfLargePrices(t *testing.T) {
+	p := &PercentageChange{Min: 10, Max: 10}
+	trade := &event.Trade{Price: decimal.MustParse("90071992547409.93"), Quantity: decimal.MustParse("1")}
+
+	sell, err := p.Price(trade, Sell, Market{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sell.Price.Cmp(decimal.MustParse("99079191802150.923")), "got %s", sell.Price)
+
+	buy, err := p.Price(trade, Buy, Market{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, buy.Price.Cmp(decimal.MustParse("81064793292668.937")), "got %s", buy.Price)
+}
+
+func TestSubmitNewAssignmentFromTradeKeepsCentsOfLargePrices(t *testing.T) {
+	g := &Generator{MessageQueue: &event.MemoryQueue{}, Store: &MemoryStore{}, Pricing: &PercentageChange{}}
+
+	trade := &event.Trade{Price: decimal.MustParse("90071992547409.93"), Quantity: decimal.MustParse("1")}
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Sell))
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "90071992547409.93", records[0].Price.String())
+	}
+}
+
+func TestPercentageChangeReturnsErrorForInvalidTradePrice(t *testing.T) {
+	p := &PercentageChange{Min: 1, Max: 2}
+
+	for _, price := range []string{"0", "-1"} {
+		_, err := p.Price(&event.Trade{Price: decimal.MustParse(price), Quantity: decimal.MustParse("0.5")}, Sell, Market{})
+		assert.Error(t, err, price)
+	}
+}
+
+// fixedPricing quotes a fixed price, and records the markets it was given
+type fixedPricing struct {
+	price   float64
+	markets []Market
+}
+
+func (p *fixedPricing) Price(trade *event.Trade, t Type, m Market) (Quote, error) {
+	p.markets = append(p.markets, m)
+	price, err := decimal.NewFromFloat(p.price)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Price: price, Quantity: trade.Quantity}, nil
+}
+
+func TestSubmitNewAssignmentFromTradeRoundsQuotedPrice(t *testing.T) {
+	queue := &event.MemoryQueue{}
+	g := &Generator{MessageQueue: queue, Store: &MemoryStore{}, Pricing: &fixedPricing{price: 2.2351}}
+
+	assignments, err := queue.Subscribe(context.Background(), event.TopicSellerAssignment, event.GroupSeller)
+	assert.NoError(t, err)
+
+	err = g.submitNewAssignmentFromTrade(&event.Trade{Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("0.5")}, Sell)
+	assert.NoError(t, err)
+
+	published := &Assignment{}
+	assert.NoError(t, json.Unmarshal((<-assignments).Value, &published))
+	assert.Equal(t, "2.24", published.Price.String())
+	assert.Equal(t, "0.5", published.Quantity.String())
+}
+
+func TestSubmitNewAssignmentFromTradeGivesStrategyLastTrade(t *testing.T) {
+	pricing := &fixedPricing{price: 2.24}
+	g := &Generator{MessageQueue: &event.MemoryQueue{}, Store: &MemoryStore{}, Pricing: pricing}
+
+	before := time.Now()
+	assert.NoError(t, g.submitNewAssignmentFromTrade(&event.Trade{Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("1")}, Buy))
+	assert.NoError(t, g.submitNewAssignmentFromTrade(&event.Trade{Price: decimal.MustParse("2.30"), Quantity: decimal.MustParse("1")}, Sell))
+
+	if assert.Len(t, pricing.markets, 2) {
+		first, second := pricing.markets[0], pricing.markets[1]
+		assert.True(t, first.LastPrice.IsZero())
+		assert.True(t, first.LastTradeAt.IsZero())
+		assert.False(t, first.Now.Before(before))
+
+		assert.Equal(t, "2.20", second.LastPrice.String())
+		assert.Equal(t, first.Now, second.LastTradeAt)
+		assert.False(t, second.Now.Before(second.LastTradeAt))
+	}
+}
+
+func TestSubmitNewAssignmentFromTradeRejectsInvalidQuotedPrice(t *testing.T) {
+	for _, price := range []float64{0, 0.004, -1} {
+		t.Run(strconv.FormatFloat(price, 'f', -1, 64), func(t *testing.T) {
+			store := &MemoryStore{}
+			g := &Generator{MessageQueue: &event.MemoryQueue{}, Store: store, Pricing: &fixedPricing{price: price}}
+
+			err := g.submitNewAssignmentFromTrade(&event.Trade{Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("1")}, Buy)
+			assert.Error(t, err)
+
+			records, err := store.List(Filter{})
+			assert.NoError(t, err)
+			assert.Empty(t, records)
+		})
+	}
+}
+
+func TestMeanReversionPullsPriceTowardAnchor(t *testing.T) {
+	// Half way back to the anchor in log terms after one time unit
+	p := &MeanReversion{Anchor: 2, Speed: math.Ln2, TimeUnit: time.Minute}
+	now := time.Now()
+	m := Market{Now: now, LastTradeAt: now.Add(-time.Minute)}
+
+	quote, err := p.Price(&event.Trade{Price: decimal.MustParse("8"), Quantity: decimal.MustParse("0.5")}, Buy, m)
+	assert.NoError(t, err)
+	assert.InDelta(t, 4, quote.Price.Float64(), 1e-9)
+	assert.Equal(t, "0.5", quote.Quantity.String())
+
+	quote, err = p.Price(&event.Trade{Price: decimal.MustParse("0.5")}, Sell, m)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1, quote.Price.Float64(), 1e-9)
+}
+
+func TestMeanReversionSpreadsBuyBelowAndSellAboveFairValue(t *testing.T) {
+	p := &MeanReversion{Anchor: 2, Speed: 1, Spread: 1}
+	trade := &event.Trade{Price: decimal.MustParse("2"), Quantity: decimal.MustParse("1")}
+
+	buy, err := p.Price(trade, Buy, Market{})
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.98, buy.Price.Float64(), 1e-9)
+
+	sell, err := p.Price(trade, Sell, Market{})
+	assert.NoError(t, err)
+	assert.InDelta(t, 2.02, sell.Price.Float64(), 1e-9)
+}
+
+func mustFloat(t *testing.T, f float64) decimal.Decimal {
+	d, err := decimal.NewFromFloat(f)
+	assert.NoError(t, err)
+	return d
+}
+
+func TestMeanReversionStaysNearAnchorOverLongSession(t *testing.T) {
+	p := &MeanReversion{Anchor: 10, Speed: 0.5, Volatility: 0.05, Spread: 1}
+	now := time.Now()
+	m := Market{Now: now, LastTradeAt: now.Add(-time.Second)}
+
+	price := 10.0
+	for i := 0; i < 10000; i++ {
+		quote, err := p.Price(&event.Trade{Price: mustFloat(t, price)}, Type(i%2), m)
+		assert.NoError(t, err)
+		price = quote.Price.Float64()
+		// The stationary standard deviation of the log price is 0.05, so this
+		// is over 10 standard deviations away
+		if !assert.True(t, price > 5 && price < 20, "price %v drifted too far after %d trades", price, i) {
+			return
+		}
+	}
+}
+
+func TestMeanReversionHoldsFairValueWhenClockStepsBack(t *testing.T) {
+	p := &MeanReversion{Anchor: 2, Speed: 1, Volatility: 0.1}
+	now := time.Now().UTC()
+	m := Market{Now: now, LastTradeAt: now.Add(time.Minute)}
+
+	quote, err := p.Price(&event.Trade{Price: decimal.MustParse("3"), Quantity: decimal.MustParse("1")}, Buy, m)
+	assert.NoError(t, err)
+	assert.InDelta(t, 3, quote.Price.Float64(), 1e-9)
+}
+
+func TestMeanReversionReturnsErrorForInvalidTradePrice(t *testing.T) {
+	p := &MeanReversion{Anchor: 2, Speed: 1}
+
+	for _, price := range []string{"0", "-1"} {
+		_, err := p.Price(&event.Trade{Price: decimal.MustParse(price)}, Buy, Market{})
+		assert.Error(t, err, price)
+	}
+}
+
+func TestGeometricBrownianMotionStartsFromFirstTradePrice(t *testing.T) {
+	p := &GeometricBrownianMotion{Volatility: 0.1, Spread: 1}
+	m := Market{Now: time.Now()}
+
+	buy, err := p.Price(&event.Trade{Price: decimal.MustParse("2.00"), Quantity: decimal.MustParse("0.5")}, Buy, m)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.98, buy.Price.Float64(), 1e-9)
+	assert.Equal(t, "0.5", buy.Quantity.String())
+
+	// No time has passed, so the reference price hasn't moved, whatever the
+	// price of the trade
+	sell, err := p.Price(&event.Trade{Price: decimal.MustParse("3.00"), Quantity: decimal.MustParse("1")}, Sell, m)
+	assert.NoError(t, err)
+	assert.InDelta(t, 2.02, sell.Price.Float64(), 1e-9)
+}
+
+func TestGeometricBrownianMotionDriftsWithTime(t *testing.T) {
+	p := &GeometricBrownianMotion{InitialPrice: 2, Drift: 0.1, TimeUnit: time.Minute}
+	start := time.Now()
+	trade := &event.Trade{Price: decimal.MustParse("5.00")}
+
+	quote, err := p.Price(trade, Buy, Market{Now: start})
+	assert.NoError(t, err)
+	assert.InDelta(t, 2, quote.Price.Float64(), 1e-9)
+
+	quote, err = p.Price(trade, Buy, Market{Now: start.Add(10 * time.Minute)})
+	assert.NoError(t, err)
+	assert.InDelta(t, 2*math.E, quote.Price.Float64(), 1e-9)
+}
+
+func TestGeometricBrownianMotionTrendsWithDrift(t *testing.T) {
+	up := &GeometricBrownianMotion{InitialPrice: 10, Drift: 0.01, Volatility: 0.01}
+	down := &GeometricBrownianMotion{InitialPrice: 10, Drift: -0.01, Volatility: 0.01}
+	start := time.Now()
+	trade := &event.Trade{Price: decimal.MustParse("10")}
+
+	var upQuote, downQuote Quote
+	for i := 0; i <= 1000; i++ {
+		m := Market{Now: start.Add(time.Duration(i) * time.Second)}
+
+		var err error
+		upQuote, err = up.Price(trade, Sell, m)
+		assert.NoError(t, err)
+		downQuote, err = down.Price(trade, Sell, m)
+		assert.NoError(t, err)
+	}
+
+	// The log price is expected to move by about 10, with a standard
+	// deviation of about 0.3
+	assert.Greater(t, upQuote.Price.Float64(), 10*math.Exp(8))
+	assert.Less(t, downQuote.Price.Float64(), 10*math.Exp(-8))
+}
+
+func TestGeometricBrownianMotionReturnsErrorForInvalidFirstTradePrice(t *testing.T) {
+	p := &GeometricBrownianMotion{}
+
+	_, err := p.Price(&event.Trade{}, Buy, Market{Now: time.Now()})
+	assert.Error(t, err)
+}
+
+func TestSubmitNewAssignmentFromTradeSnapsToInstrument(t *testing.T) {
+	queue := &event.MemoryQueue{}
+	g := &Generator{
+		MessageQueue: queue,
+		Store:        &MemoryStore{},
+		Pricing:      &fixedPricing{price: 2.2351},
+		Instrument:   Instrument{TickSize: decimal.MustParse("0.05"), LotSize: decimal.MustParse("10")},
+	}
+
+	assignments, err := queue.Subscribe(context.Background(), event.TopicBuyerAssignment, event.GroupBuyer)
+	assert.NoError(t, err)
+
+	err = g.submitNewAssignmentFromTrade(&event.Trade{Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("25")}, Buy)
+	assert.NoError(t, err)
+
+	published := &Assignment{}
+	assert.NoError(t, json.Unmarshal((<-assignments).Value, &published))
+	assert.Equal(t, "2.25", published.Price.String())
+	assert.Equal(t, "20", published.Quantity.String())
+
+	err = g.submitNewAssignmentFromTrade(&event.Trade{Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("5")}, Buy)
+	assert.Error(t, err)
+}
+
+func TestGeneratorIsReproducibleFromItsSeed(t *testing.T) {
+	run := func(seed int64) []string {
+		g := &Generator{
+			MessageQueue: &event.MemoryQueue{},
+			Store:        &MemoryStore{},
+			Pricing:      &PercentageChange{Min: 1, Max: 5},
+			Rand:         NewRand(seed),
+		}
+
+		for i := 0; i < 10; i++ {
+			trade := &event.Trade{Price: decimal.MustParse("100.00"), Quantity: decimal.MustParse("1")}
+			assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Type(i%2)))
+		}
+
+		records, err := g.Store.List(Filter{})
+		assert.NoError(t, err)
+		prices := []string{}
+		for _, r := range records {
+			prices = append(prices, r.Price.String())
+		}
+		return prices
+	}
+
+	assert.Equal(t, run(42), run(42))
+	assert.NotEqual(t, run(42), run(43))
+}
+
+func TestStrategiesDrawFromTheMarketsRand(t *testing.T) {
+	strategies := map[string]func() PricingStrategy{
+		"PercentageChange":        func() PricingStrategy { return &PercentageChange{Min: 1, Max: 5} },
+		"MeanReversion":           func() PricingStrategy { return &MeanReversion{Anchor: 100, Speed: 0.1, Volatility: 0.1} },
+		"GeometricBrownianMotion": func() PricingStrategy { return &GeometricBrownianMotion{Volatility: 0.1} },
+	}
+
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	trade := &event.Trade{Price: decimal.MustParse("100.00"), Quantity: decimal.MustParse("1")}
+	for name, newStrategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			prices := func() []float64 {
+				p, r := newStrategy(), NewRand(7)
+				prices := []float64{}
+				for i := 0; i < 5; i++ {
+					quote, err := p.Price(trade, Buy, Market{Now: start.Add(time.Duration(i) * time.Minute), Rand: r})
+					assert.NoError(t, err)
+					prices = append(prices, quote.Price.Float64())
+				}
+				return prices
+			}
+
+			assert.Equal(t, prices(), prices())
+		})
+	}
+}
+
+// steppingClock moves on by a minute each time it is read
+func steppingClock(start time.Time) func() time.Time {
+	now := start
+	return func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+}
+
+func TestGeneratorWithClockIsReproducibleForTimeBasedStrategies(t *testing.T) {
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	run := func() []string {
+		g := &Generator{
+			MessageQueue: &event.MemoryQueue{},
+			Store:        &MemoryStore{},
+			Pricing:      &GeometricBrownianMotion{Volatility: 0.1, Spread: 1},
+			Rand:         NewRand(42),
+			Clock:        steppingClock(start),
+		}
+
+		for i := 0; i < 10; i++ {
+			trade := &event.Trade{Price: decimal.MustParse("100.00"), Quantity: decimal.MustParse("1")}
+			assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Type(i%2)))
+		}
+
+		records, err := g.Store.List(Filter{})
+		assert.NoError(t, err)
+		prices := []string{}
+		for _, r := range records {
+			prices = append(prices, r.Price.String())
+		}
+		return prices
+	}
+
+	assert.Equal(t, run(), run())
+}
+
+// orderedPricing records the trades it priced and the prices it quoted, in
+// the order it quoted them
+type orderedPricing struct {
+	PercentageChange
+	trades []event.Trade
+	types  []Type
+	prices []string
+}
+
+func (p *orderedPricing) Price(trade *event.Trade, t Type, m Market) (Quote, error) {
+	quote, err := p.PercentageChange.Price(trade, t, m)
+	p.trades = append(p.trades, *trade)
+	p.types = append(p.types, t)
+	p.prices = append(p.prices, quote.Price.String())
+	return quote, err
+}
+
+func TestGeneratorDrawsInTheOrderTradesAreProcessed(t *testing.T) {
+	concurrent := &orderedPricing{PercentageChange: PercentageChange{Min: 1, Max: 5}}
+	g := &Generator{MessageQueue: &event.MemoryQueue{}, Store: &MemoryStore{}, Pricing: concurrent, Rand: NewRand(42)}
+
+	var wg sync.WaitGroup
+	for _, typ := range []Type{Buy, Sell} {
+		wg.Add(1)
+		go func(typ Type) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				trade := &event.Trade{Price: decimal.New(int64(10000+i), 2), Quantity: decimal.MustParse("1")}
+				assert.NoError(t, g.submitNewAssignmentFromTrade(trade, typ))
+			}
+		}(typ)
+	}
+	wg.Wait()
+
+	replayed := &orderedPricing{PercentageChange: PercentageChange{Min: 1, Max: 5}}
+	g = &Generator{MessageQueue: &event.MemoryQueue{}, Store: &MemoryStore{}, Pricing: replayed, Rand: NewRand(42)}
+	for i := range concurrent.trades {
+		assert.NoError(t, g.submitNewAssignmentFromTrade(&concurrent.trades[i], concurrent.types[i]))
+	}
+
+	assert.Equal(t, concurrent.prices, replayed.prices)
+}
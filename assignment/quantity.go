@@ -0,0 +1,154 @@
+package assignment
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+)
+
+// QuantityStrategy decides the quantity of the assignment generated from a
+// trade. The quantity is rounded down to a whole number of lots when the
+// assignment is submitted, or up to one lot if it is less than that.
+// Without a strategy, a trade for less than a lot generates no assignment.
+type QuantityStrategy interface {
+	Quantity(trade *event.Trade, m Market) (decimal.Decimal, error)
+}
+
+// FixedQuantity gives every assignment the same quantity
+type FixedQuantity struct {
+	Size decimal.Decimal
+}
+
+// Quantity returns the fixed size
+func (q *FixedQuantity) Quantity(_ *event.Trade, _ Market) (decimal.Decimal, error) {
+	return q.Size, nil
+}
+
+// RangeQuantity gives assignments a uniformly random quantity from Min to Max
+// inclusive, to as many decimal places as the more precise of them
+type RangeQuantity struct {
+	Min decimal.Decimal
+	Max decimal.Decimal
+}
+
+// Quantity returns a random size in the range, drawn from the whole steps of
+// its precision between Min and Max
+func (q *RangeQuantity) Quantity(_ *event.Trade, m Market) (decimal.Decimal, error) {
+	places := q.Min.Scale()
+	if q.Max.Scale() > places {
+		places = q.Max.Scale()
+	}
+
+	steps := int64(math.Round(q.Max.Sub(q.Min).Float64() * math.Pow10(int(places))))
+	if steps < 0 {
+		return decimal.Decimal{}, fmt.Errorf("Quantity range minimum %s is above its maximum %s", q.Min, q.Max)
+	}
+
+	return q.Min.Add(decimal.New(m.rand().Int63n(steps+1), places)), nil
+}
+
+// ProportionalQuantity gives assignments a quantity in proportion to the
+// trade's, so a Ratio of 0.5 halves it
+type ProportionalQuantity struct {
+	Ratio decimal.Decimal
+}
+
+// Quantity returns the trade's quantity scaled by the ratio
+func (q *ProportionalQuantity) Quantity(trade *event.Trade, _ Market) (decimal.Decimal, error) {
+	return trade.Quantity.Mul(q.Ratio), nil
+}
+
+// WeightedQuantity is a quantity in a distribution, drawn in proportion to
+// its weight
+type WeightedQuantity struct {
+	Size   decimal.Decimal
+	Weight float64
+}
+
+// UnmarshalText decodes the quantity from size:weight, such as 10:0.5. The
+// weight defaults to 1.
+func (w *WeightedQuantity) UnmarshalText(text []byte) error {
+	parts := strings.SplitN(string(text), ":", 2)
+
+	size, err := decimal.Parse(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return fmt.Errorf("Invalid size in weighted quantity %q: %w", text, err)
+	}
+
+	weight := 1.0
+	if len(parts) == 2 {
+		weight, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || weight <= 0 {
+			return fmt.Errorf("Invalid weight in weighted quantity %q, expected a positive number", text)
+		}
+	}
+
+	*w = WeightedQuantity{Size: size, Weight: weight}
+	return nil
+}
+
+// DistributionQuantity gives assignments a quantity drawn from a discrete
+// distribution of sizes
+type DistributionQuantity struct {
+	Sizes []WeightedQuantity
+}
+
+// Quantity returns a size drawn from the distribution
+func (q *DistributionQuantity) Quantity(_ *event.Trade, m Market) (decimal.Decimal, error) {
+	total := 0.0
+	for _, s := range q.Sizes {
+		total += s.Weight
+	}
+	if total <= 0 {
+		return decimal.Decimal{}, fmt.Errorf("Quantity distribution has no weighted sizes")
+	}
+
+	draw := m.rand().Float64() * total
+	for _, s := range q.Sizes {
+		if draw < s.Weight {
+			return s.Size, nil
+		}
+		draw -= s.Weight
+	}
+	return q.Sizes[len(q.Sizes)-1].Size, nil
+}
+
+// QuantityBounds are the smallest and largest quantities the generator can
+// issue assignments for. Generated quantities outside of them are moved onto
+// the nearest bound. A zero bound is no bound.
+type QuantityBounds struct {
+	Min decimal.Decimal
+	Max decimal.Decimal
+}
+
+// Validate checks that the bounds are whole numbers of the instrument's lots,
+// and that the minimum isn't above the maximum
+func (b QuantityBounds) Validate(i Instrument) error {
+	for _, bound := range []decimal.Decimal{b.Min, b.Max} {
+		if bound.Sign() < 0 {
+			return fmt.Errorf("Quantity bound %s must not be negative", bound)
+		}
+		if !bound.IsMultipleOf(i.LotSize) {
+			return fmt.Errorf("Quantity bound %w %s: %s", ErrOffLot, i.LotSize, bound)
+		}
+	}
+	if !b.Min.IsZero() && !b.Max.IsZero() && b.Min.Cmp(b.Max) > 0 {
+		return fmt.Errorf("Minimum quantity %s must not be above the maximum %s", b.Min, b.Max)
+	}
+	return nil
+}
+
+// clamp moves quantity onto the nearest bound if it is outside of them
+func (b QuantityBounds) clamp(quantity decimal.Decimal) decimal.Decimal {
+	if !b.Min.IsZero() && quantity.Cmp(b.Min) < 0 {
+		return b.Min
+	}
+	if !b.Max.IsZero() && quantity.Cmp(b.Max) > 0 {
+		return b.Max
+	}
+	return quantity
+}
@@ -0,0 +1,175 @@
+package assignment
+
+import (
+	"testing"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixedQuantityIgnoresTrade(t *testing.T) {
+	q := &FixedQuantity{Size: decimal.MustParse("5")}
+
+	size, err := q.Quantity(&event.Trade{Quantity: decimal.MustParse("100")}, Market{})
+	assert.NoError(t, err)
+	assert.Equal(t, "5", size.String())
+}
+
+func TestRangeQuantityStaysWithinRange(t *testing.T) {
+	q := &RangeQuantity{Min: decimal.MustParse("1.5"), Max: decimal.MustParse("3")}
+	m := Market{Rand: NewRand(1)}
+
+	for i := 0; i < 100; i++ {
+		size, err := q.Quantity(&event.Trade{}, m)
+		assert.NoError(t, err)
+		assert.True(t, size.Cmp(q.Min) >= 0 && size.Cmp(q.Max) <= 0, "%s is outside of range", size)
+		assert.Equal(t, int32(1), size.Scale())
+	}
+}
+
+func TestRangeQuantityDrawsBothEndsOfRange(t *testing.T) {
+	q := &RangeQuantity{Min: decimal.MustParse("1"), Max: decimal.MustParse("3")}
+	m := Market{Rand: NewRand(1)}
+
+	drawn := map[string]int{}
+	for i := 0; i < 300; i++ {
+		size, err := q.Quantity(&event.Trade{}, m)
+		assert.NoError(t, err)
+		drawn[size.String()]++
+	}
+
+	assert.Len(t, drawn, 3)
+	for _, size := range []string{"1", "2", "3"} {
+		assert.Greater(t, drawn[size], 50, size)
+	}
+}
+
+func TestProportionalQuantityScalesTrade(t *testing.T) {
+	q := &ProportionalQuantity{Ratio: decimal.MustParse("0.5")}
+
+	size, err := q.Quantity(&event.Trade{Quantity: decimal.MustParse("3")}, Market{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, size.Cmp(decimal.MustParse("1.5")))
+}
+
+func TestDistributionQuantityDrawsSizesInProportionToWeight(t *testing.T) {
+	q := &DistributionQuantity{Sizes: []WeightedQuantity{
+		{Size: decimal.MustParse("1"), Weight: 3},
+		{Size: decimal.MustParse("10"), Weight: 1},
+	}}
+	m := Market{Rand: NewRand(1)}
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		size, err := q.Quantity(&event.Trade{}, m)
+		assert.NoError(t, err)
+		counts[size.String()]++
+	}
+
+	assert.Len(t, counts, 2)
+	assert.InDelta(t, 3000, counts["1"], 150)
+	assert.InDelta(t, 1000, counts["10"], 150)
+}
+
+func TestDistributionQuantityReturnsErrorWhenEmpty(t *testing.T) {
+	_, err := (&DistributionQuantity{}).Quantity(&event.Trade{}, Market{})
+	assert.Error(t, err)
+}
+
+func TestWeightedQuantityUnmarshalText(t *testing.T) {
+	tests := []struct {
+		text   string
+		size   string
+		weight float64
+		valid  bool
+	}{
+		{"10:0.5", "10", 0.5, true},
+		{" 2.5 : 3 ", "2.5", 3, true},
+		{"7", "7", 1, true},
+		{"a:1", "", 0, false},
+		{"1:a", "", 0, false},
+		{"1:0", "", 0, false},
+		{"1:-2", "", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			w := WeightedQuantity{}
+			err := w.UnmarshalText([]byte(test.text))
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.size, w.Size.String())
+			assert.Equal(t, test.weight, w.Weight)
+		})
+	}
+}
+
+func TestQuantityBoundsValidate(t *testing.T) {
+	lots := Instrument{LotSize: decimal.MustParse("10")}
+	tests := []struct {
+		name   string
+		bounds QuantityBounds
+		valid  bool
+	}{
+		{"None", QuantityBounds{}, true},
+		{"MinAndMax", QuantityBounds{Min: decimal.MustParse("10"), Max: decimal.MustParse("100")}, true},
+		{"Negative", QuantityBounds{Min: decimal.MustParse("-10")}, false},
+		{"OffLot", QuantityBounds{Max: decimal.MustParse("105")}, false},
+		{"MinAboveMax", QuantityBounds{Min: decimal.MustParse("100"), Max: decimal.MustParse("10")}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.bounds.Validate(lots)
+			assert.Equal(t, test.valid, err == nil, "%v", err)
+		})
+	}
+}
+
+func TestSubmitNewAssignmentFromTradeRoundsQuantityBelowLotUpToOneLot(t *testing.T) {
+	g := &Generator{
+		MessageQueue: &event.MemoryQueue{},
+		Store:        &MemoryStore{},
+		Pricing:      &fixedPricing{price: 2.24},
+		Quantity:     &ProportionalQuantity{Ratio: decimal.MustParse("0.01")},
+		Instrument:   Instrument{LotSize: decimal.MustParse("1")},
+	}
+
+	trade := &event.Trade{Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("5")}
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Buy))
+
+	r, err := g.Store.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", r.Quantity.String())
+}
+
+func TestSubmitNewAssignmentFromTradeSizesWithQuantityStrategyWithinBounds(t *testing.T) {
+	quantity := &FixedQuantity{Size: decimal.MustParse("37")}
+	g := &Generator{
+		MessageQueue:   &event.MemoryQueue{},
+		Store:          &MemoryStore{},
+		Pricing:        &fixedPricing{price: 2.24},
+		Quantity:       quantity,
+		Instrument:     Instrument{LotSize: decimal.MustParse("5")},
+		QuantityBounds: QuantityBounds{Min: decimal.MustParse("10"), Max: decimal.MustParse("50")},
+	}
+
+	trade := &event.Trade{Price: decimal.MustParse("2.20"), Quantity: decimal.MustParse("1")}
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Buy))
+	quantity.Size = decimal.MustParse("3")
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Buy))
+	quantity.Size = decimal.MustParse("80")
+	assert.NoError(t, g.submitNewAssignmentFromTrade(trade, Buy))
+
+	records, err := g.Store.List(Filter{})
+	assert.NoError(t, err)
+	sizes := []string{}
+	for _, r := range records {
+		sizes = append(sizes, r.Quantity.String())
+	}
+	assert.Equal(t, []string{"35", "10", "50"}, sizes)
+}
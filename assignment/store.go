@@ -0,0 +1,279 @@
+package assignment
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+)
+
+var (
+	// ErrNotFound is returned when there is no record of an assignment
+	ErrNotFound error = errors.New("Assignment not found")
+)
+
+// Status is where an issued assignment is in its lifecycle
+type Status int
+
+const (
+	// Issued is an assignment that hasn't been traded yet
+	Issued Status = iota
+	// PartiallyFilled is an assignment that has been traded for less than
+	// its full quantity
+	PartiallyFilled
+	// Filled is an assignment that has been traded for its full quantity
+	Filled
+	// Expired is an assignment that wasn't fully traded before it expired
+	Expired
+)
+
+var statusNames = map[Status]string{
+	Issued:          "issued",
+	PartiallyFilled: "partially-filled",
+	Filled:          "filled",
+	Expired:         "expired",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// MarshalText encodes the status as its name, such as partially-filled
+func (s Status) MarshalText() ([]byte, error) {
+	name, ok := statusNames[s]
+	if !ok {
+		return nil, fmt.Errorf("Unknown assignment status %d", int(s))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes the status from its name, such as partially-filled
+func (s *Status) UnmarshalText(text []byte) error {
+	for status, name := range statusNames {
+		if name == string(text) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown assignment status %q, expected issued, partially-filled, filled or expired", text)
+}
+
+// Record is an assignment that has been issued, as kept by a Store, along
+// with how much of it has been traded
+type Record struct {
+	Assignment
+	Type      Type            `json:"type"`
+	Status    Status          `json:"status"`
+	Filled    decimal.Decimal `json:"filled"`
+	CreatedAt time.Time       `json:"createdAt"`
+	// FilledBy identifies the trades that have filled the assignment, so a
+	// trade that is delivered again doesn't fill it twice
+	FilledBy []string `json:"filledBy,omitempty"`
+	// Unconfirmed is set when publishing the assignment timed out, so it may
+	// not have been given to an agent. A trade made against it confirms it.
+	Unconfirmed bool `json:"unconfirmed,omitempty"`
+}
+
+// Filter narrows down the records returned by Store.List. A zero field
+// matches every record.
+type Filter struct {
+	// Instruments are the symbols of the markets to match, where an empty
+	// symbol is the default market
+	Instruments []string
+	Types       []Type
+	Statuses    []Status
+	// MinPrice and MaxPrice are inclusive bounds on price
+	MinPrice *decimal.Decimal
+	MaxPrice *decimal.Decimal
+	// CreatedAfter and CreatedBefore are exclusive bounds on creation time
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// AfterID only matches records with a greater ID, to page through records
+	AfterID int
+	// Limit is the most records to return
+	Limit int
+}
+
+func (f Filter) matches(r Record) bool {
+	if r.ID <= f.AfterID {
+		return false
+	}
+
+	if len(f.Instruments) > 0 {
+		found := false
+		for _, i := range f.Instruments {
+			found = found || i == r.Instrument
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			found = found || t == r.Type
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, s := range f.Statuses {
+			found = found || s == r.Status
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.MinPrice != nil && r.Price.Cmp(*f.MinPrice) < 0 {
+		return false
+	}
+
+	if f.MaxPrice != nil && r.Price.Cmp(*f.MaxPrice) > 0 {
+		return false
+	}
+
+	if !f.CreatedAfter.IsZero() && !r.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+
+	if !f.CreatedBefore.IsZero() && !r.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+
+	return true
+}
+
+// full reports whether records has reached the filter's limit
+func (f Filter) full(records []Record) bool {
+	return f.Limit > 0 && len(records) >= f.Limit
+}
+
+// Store keeps a record of the assignments that have been issued
+type Store interface {
+	// Add keeps a new record, setting its assignment's ID to one that is
+	// unique within the store
+	Add(r *Record) error
+	// Get returns the record of the assignment with the given ID, or
+	// ErrNotFound if there isn't one
+	Get(id int) (Record, error)
+	// Update changes the record of the assignment with the given ID with
+	// update, which sees the record as it is at the time. The change isn't
+	// kept if update returns an error.
+	Update(id int, update func(r *Record) error) error
+	// List returns the records that match f, in order of ID, up to f.Limit
+	List(f Filter) ([]Record, error)
+	// Remove deletes the record of the assignment with the given ID
+	Remove(id int) error
+	// Close releases the store's resources
+	Close() error
+}
+
+// MemoryStore is a Store kept in memory, so its records only last as long as
+// the process. Records are never removed once filled or expired, so it grows
+// for as long as the process runs. The zero value gives IDs from 1; use
+// NewMemoryStore for IDs that don't repeat those of an earlier process.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	lastID  int
+	records map[int]Record
+}
+
+// NewMemoryStore returns a MemoryStore that gives IDs following on from the
+// time it was made in microseconds, so trades made against the assignments of
+// an earlier process don't fill its assignments
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{lastID: int(time.Now().UnixNano() / int64(time.Microsecond))}
+}
+
+// Add keeps a new record in memory, giving it the next ID in sequence
+func (s *MemoryStore) Add(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records == nil {
+		s.records = make(map[int]Record)
+	}
+
+	s.lastID++
+	r.ID = s.lastID
+	s.records[r.ID] = *r
+	return nil
+}
+
+// Get returns the record of the assignment with the given ID
+func (s *MemoryStore) Get(id int) (Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return r, nil
+}
+
+// Update changes the record of the assignment with the given ID
+func (s *MemoryStore) Update(id int, update func(r *Record) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if err := update(&r); err != nil {
+		return err
+	}
+
+	r.ID = id
+	s.records[id] = r
+	return nil
+}
+
+// List returns the records that match f, in order of ID
+func (s *MemoryStore) List(f Filter) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := []Record{}
+	for _, r := range s.records {
+		if f.matches(r) {
+			records = append(records, r)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = records[:f.Limit]
+	}
+	return records, nil
+}
+
+// Remove deletes the record of the assignment with the given ID
+func (s *MemoryStore) Remove(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, id)
+	return nil
+}
+
+// Close does nothing, as there are no resources to release
+func (s *MemoryStore) Close() error {
+	return nil
+}
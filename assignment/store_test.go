@@ -0,0 +1,305 @@
+package assignment
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// withStores runs test against each Store implementation
+func withStores(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("MemoryStore", func(t *testing.T) {
+		test(t, &MemoryStore{})
+	})
+
+	t.Run("BoltStore", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "assignment-store")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		s, err := NewBoltStore(filepath.Join(dir, "assignments.db"))
+		assert.NoError(t, err)
+		defer s.Close()
+
+		test(t, s)
+	})
+}
+
+func TestStoreAddGivesEachRecordUniqueID(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		first := &Record{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}, Type: Buy}
+		second := &Record{Assignment: Assignment{Price: decimal.MustParse("2.25"), Quantity: decimal.MustParse("1")}, Type: Sell}
+
+		assert.NoError(t, s.Add(first))
+		assert.NoError(t, s.Add(second))
+
+		assert.NotZero(t, first.ID)
+		assert.NotZero(t, second.ID)
+		assert.NotEqual(t, first.ID, second.ID)
+	})
+}
+
+func TestNewMemoryStoreGivesIDsAfterThoseOfEarlierStores(t *testing.T) {
+	earlier := NewMemoryStore()
+	last := &Record{}
+	for i := 0; i < 100; i++ {
+		last = &Record{}
+		assert.NoError(t, earlier.Add(last))
+	}
+
+	time.Sleep(time.Millisecond)
+	r := &Record{}
+	assert.NoError(t, NewMemoryStore().Add(r))
+	assert.Greater(t, r.ID, last.ID)
+}
+
+func TestStoreGetReturnsAddedRecord(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		r := &Record{
+			Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")},
+			Type:       Sell,
+			CreatedAt:  time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC),
+		}
+		assert.NoError(t, s.Add(r))
+
+		got, err := s.Get(r.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, *r, got)
+	})
+}
+
+func TestStoreGetReturnsNotFoundForUnknownID(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		_, err := s.Get(123)
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestStoreUpdateChangesRecord(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		r := &Record{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}, Type: Buy}
+		assert.NoError(t, s.Add(r))
+
+		err := s.Update(r.ID, func(r *Record) error {
+			r.Status = PartiallyFilled
+			r.Filled = decimal.MustParse("0.2")
+			return nil
+		})
+		assert.NoError(t, err)
+
+		got, err := s.Get(r.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, PartiallyFilled, got.Status)
+		assert.Equal(t, "0.2", got.Filled.String())
+	})
+}
+
+func TestStoreUpdateKeepsRecordWhenUpdateFails(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		r := &Record{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}, Type: Buy}
+		assert.NoError(t, s.Add(r))
+
+		updateErr := errors.New("can't update")
+		err := s.Update(r.ID, func(r *Record) error {
+			r.Status = Filled
+			return updateErr
+		})
+		assert.Equal(t, updateErr, err)
+
+		got, err := s.Get(r.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, Issued, got.Status)
+	})
+}
+
+func TestStoreUpdateReturnsNotFoundForUnknownID(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		err := s.Update(123, func(r *Record) error { return nil })
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestStoreListReturnsMatchingRecordsInOrder(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		created := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+		records := []*Record{
+			{Status: Issued, CreatedAt: created},
+			{Status: Filled, CreatedAt: created},
+			{Status: Issued, CreatedAt: created.Add(time.Hour)},
+			{Status: Expired, CreatedAt: created},
+		}
+		for _, r := range records {
+			assert.NoError(t, s.Add(r))
+		}
+
+		all, err := s.List(Filter{})
+		assert.NoError(t, err)
+		assert.Len(t, all, 4)
+
+		got, err := s.List(Filter{
+			Statuses:      []Status{Issued, Expired},
+			CreatedBefore: created.Add(time.Minute),
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, records[0].ID, got[0].ID)
+			assert.Equal(t, records[3].ID, got[1].ID)
+		}
+	})
+}
+
+func TestStoreListFiltersByTypeAndPrice(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		records := []*Record{
+			{Assignment: Assignment{Price: decimal.MustParse("2.20")}, Type: Buy},
+			{Assignment: Assignment{Price: decimal.MustParse("2.30")}, Type: Sell},
+			{Assignment: Assignment{Price: decimal.MustParse("2.40")}, Type: Buy},
+		}
+		for _, r := range records {
+			assert.NoError(t, s.Add(r))
+		}
+
+		min, max := decimal.MustParse("2.2"), decimal.MustParse("2.3")
+		got, err := s.List(Filter{
+			Types:    []Type{Buy},
+			MinPrice: &min,
+			MaxPrice: &max,
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, got, 1) {
+			assert.Equal(t, records[0].ID, got[0].ID)
+		}
+	})
+}
+
+func TestStoreListPagesAfterIDUpToLimit(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		records := make([]*Record, 5)
+		for i := range records {
+			records[i] = &Record{Type: Type(i % 2)}
+			assert.NoError(t, s.Add(records[i]))
+		}
+
+		got, err := s.List(Filter{AfterID: records[0].ID, Limit: 2})
+		assert.NoError(t, err)
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, records[1].ID, got[0].ID)
+			assert.Equal(t, records[2].ID, got[1].ID)
+		}
+
+		got, err = s.List(Filter{Types: []Type{Buy}, AfterID: records[0].ID, Limit: 2})
+		assert.NoError(t, err)
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, records[2].ID, got[0].ID)
+			assert.Equal(t, records[4].ID, got[1].ID)
+		}
+	})
+}
+
+func TestStoreListFiltersByCreationTimeAfterUpdatesAndRemoves(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		created := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+		records := []*Record{
+			{CreatedAt: created.Add(2 * time.Hour)},
+			{CreatedAt: created},
+			{CreatedAt: created.Add(time.Hour)},
+			{CreatedAt: created.Add(3 * time.Hour)},
+		}
+		for _, r := range records {
+			assert.NoError(t, s.Add(r))
+		}
+
+		assert.NoError(t, s.Update(records[3].ID, func(r *Record) error {
+			r.CreatedAt = created.Add(-time.Hour)
+			return nil
+		}))
+		assert.NoError(t, s.Remove(records[1].ID))
+
+		got, err := s.List(Filter{
+			CreatedAfter:  created.Add(-time.Hour),
+			CreatedBefore: created.Add(3 * time.Hour),
+			Limit:         1,
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, got, 1) {
+			assert.Equal(t, records[0].ID, got[0].ID)
+		}
+
+		got, err = s.List(Filter{CreatedBefore: created.Add(time.Hour)})
+		assert.NoError(t, err)
+		if assert.Len(t, got, 1) {
+			assert.Equal(t, records[3].ID, got[0].ID)
+		}
+	})
+}
+
+func TestStoreRemoveDeletesRecord(t *testing.T) {
+	withStores(t, func(t *testing.T, s Store) {
+		r := &Record{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}, Type: Buy}
+		assert.NoError(t, s.Add(r))
+
+		assert.NoError(t, s.Remove(r.ID))
+
+		_, err := s.Get(r.ID)
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestBoltStoreKeepsRecordsAcrossReopens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assignment-store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "assignments.db")
+
+	s, err := NewBoltStore(path)
+	assert.NoError(t, err)
+	r := &Record{Assignment: Assignment{Price: decimal.MustParse("2.24"), Quantity: decimal.MustParse("0.5")}, Type: Buy}
+	assert.NoError(t, s.Add(r))
+	assert.NoError(t, s.Close())
+
+	s, err = NewBoltStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	got, err := s.Get(r.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, r.Assignment, got.Assignment)
+
+	next := &Record{Assignment: Assignment{Price: decimal.MustParse("2.25"), Quantity: decimal.MustParse("1")}, Type: Sell}
+	assert.NoError(t, s.Add(next))
+	assert.Greater(t, next.ID, r.ID)
+}
+
+func TestBoltStoreIndexesRecordsOfStoreWithoutIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assignment-store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "assignments.db")
+
+	created := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	s, err := NewBoltStore(path)
+	assert.NoError(t, err)
+	r := &Record{CreatedAt: created}
+	assert.NoError(t, s.Add(r))
+	assert.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(createdBucket)
+	}))
+	assert.NoError(t, s.Close())
+
+	s, err = NewBoltStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	got, err := s.List(Filter{CreatedBefore: created.Add(time.Minute)})
+	assert.NoError(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, r.ID, got[0].ID)
+	}
+}
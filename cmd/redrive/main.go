@@ -0,0 +1,65 @@
+// Command redrive moves trades that were dead-lettered by the assignment
+// server back onto the topic they were originally read from, so they can be
+// processed again once the cause of the failure has been fixed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/stevestotter/assignment-server/config"
+	"github.com/stevestotter/assignment-server/event"
+)
+
+func main() {
+	topic := flag.String("topic", "", "topic whose dead letters should be redriven, e.g. buyer-trade")
+	idle := flag.Duration("idle", 10*time.Second, "stop once no further dead letter has arrived for this long")
+	flag.Parse()
+
+	if *topic == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("Error processing env config: %s", err)
+	}
+
+	queue := &event.KafkaQueue{
+		URL:          cfg.Kafka.URL,
+		Delivery:     event.AtLeastOnce,
+		BatchSize:    cfg.Kafka.WriterBatchSize,
+		BatchTimeout: cfg.Kafka.WriterBatchTimeout,
+		RequiredAcks: cfg.Kafka.WriterRequiredAcks,
+		Retry:        cfg.Kafka.RetryPolicy(),
+		// Dead letters are few and small, so they're fetched as soon as
+		// they're there rather than batched up
+		ReadMinBytes: 1,
+		ReadMaxWait:  500 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	moved, err := event.Redrive(ctx, queue, *topic, *idle)
+	if closeErr := queue.Close(); closeErr != nil {
+		log.Printf("Error closing event queue: %s", closeErr)
+	}
+
+	fmt.Printf("Redrove %d dead letters from %s to %s\n", moved, event.DeadLetterTopic(*topic), *topic)
+	if err != nil {
+		log.Fatalf("Redrive stopped early: %s", err)
+	}
+}
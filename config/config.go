@@ -1,24 +1,232 @@
 package config
 
-import "github.com/caarlos0/env/v6"
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/stevestotter/assignment-server/assignment"
+	"github.com/stevestotter/assignment-server/decimal"
+	"github.com/stevestotter/assignment-server/event"
+)
 
 type Config struct {
-	API       API
-	Kafka     Kafka
-	Generator Generator
+	API        API
+	Queue      Queue
+	Kafka      Kafka
+	Store      Store
+	Instrument Instrument
+	Generator  Generator
+	// Instruments are the symbols of the markets to run side by side with
+	// the default market, which is always run
+	Instruments []string `env:"INSTRUMENTS" envSeparator:","`
+	// Markets is the config of each market to run, keyed by the symbol of
+	// its instrument. The default market has an empty symbol.
+	Markets map[string]Market
+	// Agents are the IDs of the agents assignments can be targeted at, each
+	// of which has its own assignment topics. None allows no targeting.
+	Agents []string `env:"AGENTS" envSeparator:","`
+}
+
+// Market is the config of one instrument's market. A setting can be given
+// for just one instrument by prefixing it with the instrument's symbol in
+// upper case, such as EURUSD_INSTRUMENT_TICK_SIZE. Otherwise the setting
+// without a prefix is used.
+type Market struct {
+	Instrument Instrument
+	Generator  Generator
 }
 
 type API struct {
 	Port string `env:"API_PORT" envDefault:"1001"`
+	// ShutdownTimeout is how long in-flight requests, consumers and queue
+	// writes are given to finish when the server is asked to stop
+	ShutdownTimeout time.Duration `env:"API_SHUTDOWN_TIMEOUT" envDefault:"10s"`
+	// DrainDelay is how long /readyz fails for before the server stops
+	// accepting requests on shutdown. It should be longer than the interval
+	// load balancers check readiness at.
+	DrainDelay time.Duration `env:"API_DRAIN_DELAY" envDefault:"0s"`
+	// IdempotencyWindow is how long a request repeated with the same
+	// Idempotency-Key header is given the original response, where 0 never
+	// replays responses
+	IdempotencyWindow time.Duration `env:"API_IDEMPOTENCY_WINDOW" envDefault:"24h"`
+	// IdempotencyCapacity is the most responses kept to replay, where the
+	// least recently used are forgotten first
+	IdempotencyCapacity int `env:"API_IDEMPOTENCY_CAPACITY" envDefault:"10000"`
+}
+
+type Queue struct {
+	// Backend is the event queue implementation to use - either kafka or memory
+	Backend string `env:"QUEUE_BACKEND" envDefault:"kafka"`
+	// Delivery is the guarantee given to consumers of the queue - either
+	// at-least-once or at-most-once
+	Delivery event.Delivery `env:"QUEUE_DELIVERY" envDefault:"at-least-once"`
 }
 
 type Kafka struct {
 	URL string `env:"KAFKA_URL" envDefault:"localhost:9092"`
+	// WriterBatchSize is the number of messages buffered per partition
+	// before they are written
+	WriterBatchSize int `env:"KAFKA_WRITER_BATCH_SIZE" envDefault:"100"`
+	// WriterBatchTimeout is how long an incomplete batch waits for more
+	// messages before it is written anyway
+	WriterBatchTimeout time.Duration `env:"KAFKA_WRITER_BATCH_TIMEOUT" envDefault:"10ms"`
+	// WriterRequiredAcks is the number of replicas that must acknowledge a
+	// write - -1 for all, 1 for the leader only
+	WriterRequiredAcks int `env:"KAFKA_WRITER_REQUIRED_ACKS" envDefault:"-1"`
+	// RetryMaxAttempts is the most times a write is tried before giving up
+	RetryMaxAttempts int `env:"KAFKA_RETRY_MAX_ATTEMPTS" envDefault:"5"`
+	// RetryBaseDelay is the wait before the first retry, doubling after that
+	RetryBaseDelay time.Duration `env:"KAFKA_RETRY_BASE_DELAY" envDefault:"100ms"`
+	// RetryMaxDelay caps the wait between retries
+	RetryMaxDelay time.Duration `env:"KAFKA_RETRY_MAX_DELAY" envDefault:"2s"`
+	// RetryJitter is the fraction of each wait that is randomised
+	RetryJitter float64 `env:"KAFKA_RETRY_JITTER" envDefault:"0.2"`
+	// TopicPartitions is the number of partitions topics are created with
+	// when they don't exist yet, such as those for an instrument's market or
+	// an agent's assignments, where 0 never creates topics
+	TopicPartitions int `env:"KAFKA_TOPIC_PARTITIONS" envDefault:"10"`
+	// TopicReplicationFactor is the number of replicas created topics have
+	TopicReplicationFactor int `env:"KAFKA_TOPIC_REPLICATION_FACTOR" envDefault:"1"`
+}
+
+type Store struct {
+	// Backend is where issued assignments are kept - either bolt, or memory,
+	// which loses them on restart and never frees them
+	Backend string `env:"STORE_BACKEND" envDefault:"bolt"`
+	// Path is the file the bolt store is kept in
+	Path string `env:"STORE_PATH" envDefault:"assignments.db"`
+}
+
+type Instrument struct {
+	// TickSize is the smallest amount a price can move by
+	TickSize decimal.Decimal `env:"INSTRUMENT_TICK_SIZE" envDefault:"0.01"`
+	// PricePrecision is the number of decimal places prices are given to,
+	// where 0 means as many as the tick size has
+	PricePrecision int32 `env:"INSTRUMENT_PRICE_PRECISION" envDefault:"0"`
+	// LotSize is the smallest amount a quantity can move by, where 0 allows
+	// any quantity
+	LotSize decimal.Decimal `env:"INSTRUMENT_LOT_SIZE" envDefault:"0"`
 }
 
 type Generator struct {
+	// PricingStrategy decides the price of generated assignments - one of
+	// percentage-change, mean-reversion or geometric-brownian-motion
+	PricingStrategy     string  `env:"GENERATOR_PRICING_STRATEGY" envDefault:"percentage-change"`
 	PercentageChangeMin float64 `env:"GENERATOR_PERCENTAGE_CHANGE_MIN" envDefault:"2"`
 	PercentageChangeMax float64 `env:"GENERATOR_PERCENTAGE_CHANGE_MAX" envDefault:"5"`
+	// MeanReversionAnchor is the fundamental value prices are pulled toward
+	MeanReversionAnchor float64 `env:"GENERATOR_MEAN_REVERSION_ANCHOR"`
+	// MeanReversionSpeed is how quickly prices revert per time unit
+	MeanReversionSpeed float64 `env:"GENERATOR_MEAN_REVERSION_SPEED" envDefault:"0.1"`
+	// MeanReversionVolatility is the volatility of the log price per time unit
+	MeanReversionVolatility float64 `env:"GENERATOR_MEAN_REVERSION_VOLATILITY" envDefault:"0.01"`
+	// MeanReversionSpread is the percentage buy and sell assignments are
+	// priced either side of the fair value
+	MeanReversionSpread float64 `env:"GENERATOR_MEAN_REVERSION_SPREAD" envDefault:"1"`
+	// GBMInitialPrice is the reference price to start from, where 0 means
+	// the price of the first trade
+	GBMInitialPrice float64 `env:"GENERATOR_GBM_INITIAL_PRICE"`
+	// GBMDrift is the expected fractional change in price per time unit
+	GBMDrift float64 `env:"GENERATOR_GBM_DRIFT"`
+	// GBMVolatility is the volatility of the log price per time unit
+	GBMVolatility float64 `env:"GENERATOR_GBM_VOLATILITY" envDefault:"0.01"`
+	// GBMSpread is the percentage buy and sell assignments are priced either
+	// side of the reference price
+	GBMSpread float64 `env:"GENERATOR_GBM_SPREAD" envDefault:"1"`
+	// PricingTimeUnit is the time that rates of price change are given per
+	PricingTimeUnit time.Duration `env:"GENERATOR_PRICING_TIME_UNIT" envDefault:"1m"`
+	// RoundingMode is how generated prices are snapped to the tick grid -
+	// one of half-up, half-even or truncate
+	RoundingMode decimal.RoundingMode `env:"GENERATOR_ROUNDING_MODE" envDefault:"half-up"`
+	// AssignmentTTL is how long an assignment can go untraded before it
+	// expires, where 0 means never
+	AssignmentTTL time.Duration `env:"GENERATOR_ASSIGNMENT_TTL" envDefault:"1h"`
+	// QuantityStrategy decides the quantity of generated assignments - one
+	// of trade, fixed, range, proportional or distribution
+	QuantityStrategy string `env:"GENERATOR_QUANTITY_STRATEGY" envDefault:"trade"`
+	// QuantityFixed is the quantity of every assignment for fixed
+	QuantityFixed decimal.Decimal `env:"GENERATOR_QUANTITY_FIXED" envDefault:"1"`
+	// QuantityRangeMin and QuantityRangeMax are the range quantities are
+	// drawn from for range
+	QuantityRangeMin decimal.Decimal `env:"GENERATOR_QUANTITY_RANGE_MIN" envDefault:"1"`
+	QuantityRangeMax decimal.Decimal `env:"GENERATOR_QUANTITY_RANGE_MAX" envDefault:"10"`
+	// QuantityRatio scales the trade's quantity for proportional
+	QuantityRatio decimal.Decimal `env:"GENERATOR_QUANTITY_RATIO" envDefault:"1"`
+	// QuantityDistribution is the sizes and weights quantities are drawn
+	// from for distribution, such as 1:0.5,5:0.3,10:0.2
+	QuantityDistribution []assignment.WeightedQuantity `env:"GENERATOR_QUANTITY_DISTRIBUTION" envSeparator:","`
+	// QuantityMin and QuantityMax bound generated quantities, where 0 is no
+	// bound
+	QuantityMin decimal.Decimal `env:"GENERATOR_QUANTITY_MIN" envDefault:"0"`
+	QuantityMax decimal.Decimal `env:"GENERATOR_QUANTITY_MAX" envDefault:"0"`
+	// PriceFloor and PriceCeiling bound generated prices, where 0 is no bound
+	PriceFloor   decimal.Decimal `env:"GENERATOR_PRICE_FLOOR" envDefault:"0"`
+	PriceCeiling decimal.Decimal `env:"GENERATOR_PRICE_CEILING" envDefault:"0"`
+	// CircuitBreakerThreshold is the percentage generated prices can move
+	// within CircuitBreakerWindow before the market halts for
+	// CircuitBreakerCoolDown, where 0 never halts
+	CircuitBreakerThreshold float64       `env:"GENERATOR_CIRCUIT_BREAKER_THRESHOLD"`
+	CircuitBreakerWindow    time.Duration `env:"GENERATOR_CIRCUIT_BREAKER_WINDOW" envDefault:"1m"`
+	CircuitBreakerCoolDown  time.Duration `env:"GENERATOR_CIRCUIT_BREAKER_COOL_DOWN" envDefault:"5m"`
+	// Seed seeds the generator's randomness, so the prices drawn for trades
+	// processed in the same order are the same from run to run. Strategies
+	// that move with time also depend on when trades arrive. 0 picks a seed
+	// from the time, which is logged at startup.
+	Seed int64 `env:"GENERATOR_SEED"`
+}
+
+// Bounds returns the bounds on generated prices
+func (g Generator) Bounds() assignment.PriceBounds {
+	return assignment.PriceBounds{Floor: g.PriceFloor, Ceiling: g.PriceCeiling}
+}
+
+// QuantityBounds returns the bounds on generated quantities
+func (g Generator) QuantityBounds() assignment.QuantityBounds {
+	return assignment.QuantityBounds{Min: g.QuantityMin, Max: g.QuantityMax}
+}
+
+// CircuitBreaker returns the circuit breaker for generated prices, or nil if
+// it is disabled
+func (g Generator) CircuitBreaker() *assignment.CircuitBreaker {
+	if g.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+	return &assignment.CircuitBreaker{
+		Threshold: g.CircuitBreakerThreshold,
+		Window:    g.CircuitBreakerWindow,
+		CoolDown:  g.CircuitBreakerCoolDown,
+	}
+}
+
+// RetryPolicy returns the policy for retrying failed kafka writes
+func (k Kafka) RetryPolicy() event.RetryPolicy {
+	return event.RetryPolicy{
+		MaxAttempts: k.RetryMaxAttempts,
+		BaseDelay:   k.RetryBaseDelay,
+		MaxDelay:    k.RetryMaxDelay,
+		Jitter:      k.RetryJitter,
+	}
+}
+
+// TopicSettings returns how topics that don't exist yet are created
+func (k Kafka) TopicSettings() event.TopicSettings {
+	return event.TopicSettings{
+		Partitions:        k.TopicPartitions,
+		ReplicationFactor: k.TopicReplicationFactor,
+	}
+}
+
+// Instrument returns the increments the instrument's prices and quantities
+// move in
+func (i Instrument) Instrument() assignment.Instrument {
+	return assignment.Instrument{
+		TickSize:       i.TickSize,
+		PricePrecision: i.PricePrecision,
+		LotSize:        i.LotSize,
+	}
 }
 
 func NewConfig() (*Config, error) {
@@ -27,5 +235,51 @@ func NewConfig() (*Config, error) {
 		return cfg, err
 	}
 
+	cfg.Markets = map[string]Market{
+		"": {Instrument: cfg.Instrument, Generator: cfg.Generator},
+	}
+
+	for _, symbol := range cfg.Instruments {
+		if symbol == "" {
+			return cfg, fmt.Errorf("Instrument symbols must not be empty, the default market is always run")
+		}
+		m := Market{}
+		if err := env.Parse(&m, env.Options{Environment: instrumentEnvironment(symbol)}); err != nil {
+			return cfg, fmt.Errorf("%s: %w", symbol, err)
+		}
+		cfg.Markets[symbol] = m
+	}
+
+	for _, agent := range cfg.Agents {
+		if err := assignment.CheckAgent(agent); err != nil {
+			return cfg, err
+		}
+	}
+
 	return cfg, nil
 }
+
+// instrumentEnvironment returns the environment with the variables prefixed
+// with the symbol overriding those without it
+func instrumentEnvironment(symbol string) map[string]string {
+	prefix := strings.ToUpper(strings.ReplaceAll(symbol, "-", "_")) + "_"
+	environment := map[string]string{}
+	overrides := map[string]string{}
+
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		environment[parts[0]] = parts[1]
+		if strings.HasPrefix(parts[0], prefix) {
+			overrides[strings.TrimPrefix(parts[0], prefix)] = parts[1]
+		}
+	}
+
+	for k, v := range overrides {
+		environment[k] = v
+	}
+	return environment
+}
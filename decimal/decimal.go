@@ -0,0 +1,275 @@
+// Package decimal provides an exact decimal number type for prices and
+// quantities, so they don't pick up binary rounding errors from float64.
+package decimal
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]+)?|\.[0-9]+)$`)
+
+var ten = big.NewInt(10)
+
+// Decimal is an exact decimal number, kept as an integer and the number of
+// decimal places it is scaled down by. The number of decimal places a
+// Decimal is parsed with is kept, so 2.50 stays 2.50 rather than 2.5.
+// The zero value is 0.
+type Decimal struct {
+	// unscaled is nil when it is zero, so decimals with the same value and
+	// decimal places are deeply equal
+	unscaled *big.Int
+	scale    int32
+}
+
+func newDecimal(unscaled *big.Int, scale int32) Decimal {
+	if unscaled.Sign() == 0 {
+		unscaled = nil
+	}
+	return Decimal{unscaled: unscaled, scale: scale}
+}
+
+// New returns the decimal unscaled * 10^-scale, so New(224, 2) is 2.24
+func New(unscaled int64, scale int32) Decimal {
+	if scale < 0 {
+		scale = 0
+	}
+	return newDecimal(big.NewInt(unscaled), scale)
+}
+
+// Parse reads a decimal written in plain notation, such as 2.24, -3 or .5
+func Parse(s string) (Decimal, error) {
+	if !decimalPattern.MatchString(s) {
+		return Decimal{}, fmt.Errorf("Invalid decimal %q", s)
+	}
+
+	var scale int32
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		scale = int32(len(s) - i - 1)
+		s = s[:i] + s[i+1:]
+	}
+
+	unscaled, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("Invalid decimal %q", s)
+	}
+	return newDecimal(unscaled, scale), nil
+}
+
+// MustParse is like Parse but panics if s isn't a valid decimal. It is
+// intended for constants and tests.
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// NewFromFloat returns the shortest decimal that converts back to f exactly,
+// so 0.1 is 0.1 rather than the binary fraction closest to it
+func NewFromFloat(f float64) (Decimal, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Decimal{}, fmt.Errorf("Invalid decimal %v", f)
+	}
+	return Parse(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(ten, big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) int() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return d.unscaled
+}
+
+// rescale returns the unscaled value of d with scale decimal places, which
+// must be at least d's
+func (d Decimal) rescale(scale int32) *big.Int {
+	if scale == d.scale {
+		return new(big.Int).Set(d.int())
+	}
+	return new(big.Int).Mul(d.int(), pow10(scale-d.scale))
+}
+
+// Scale returns the number of decimal places d has
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+// Sign returns -1, 0 or 1 when d is negative, zero or positive
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+// IsZero reports whether d is 0, with any number of decimal places
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Cmp returns -1, 0 or 1 when d is less than, equal to or greater than e,
+// regardless of how many decimal places each has
+func (d Decimal) Cmp(e Decimal) int {
+	scale := maxScale(d, e)
+	return d.rescale(scale).Cmp(e.rescale(scale))
+}
+
+// Add returns d + e, with as many decimal places as the more precise of them
+func (d Decimal) Add(e Decimal) Decimal {
+	scale := maxScale(d, e)
+	return newDecimal(new(big.Int).Add(d.rescale(scale), e.rescale(scale)), scale)
+}
+
+// Sub returns d - e, with as many decimal places as the more precise of them
+func (d Decimal) Sub(e Decimal) Decimal {
+	return d.Add(e.Neg())
+}
+
+// Mul returns d * e exactly, with as many decimal places as both of them
+// together
+func (d Decimal) Mul(e Decimal) Decimal {
+	return newDecimal(new(big.Int).Mul(d.int(), e.int()), d.scale+e.scale)
+}
+
+// Neg returns -d
+func (d Decimal) Neg() Decimal {
+	return newDecimal(new(big.Int).Neg(d.int()), d.scale)
+}
+
+// Abs returns the absolute value of d
+func (d Decimal) Abs() Decimal {
+	return newDecimal(new(big.Int).Abs(d.int()), d.scale)
+}
+
+// Round returns d with exactly places decimal places, rounding with mode if
+// it has more. Negative places are treated as 0.
+func (d Decimal) Round(places int32, mode RoundingMode) Decimal {
+	if places < 0 {
+		places = 0
+	}
+	if places >= d.scale {
+		return newDecimal(d.rescale(places), places)
+	}
+
+	divisor := pow10(d.scale - places)
+	q, r := new(big.Int).QuoRem(new(big.Int).Abs(d.int()), divisor, new(big.Int))
+	if mode.roundsAway(q, r, divisor) {
+		q.Add(q, big.NewInt(1))
+	}
+	if d.Sign() < 0 {
+		q.Neg(q)
+	}
+	return newDecimal(q, places)
+}
+
+// RoundToIncrement returns the multiple of increment closest to d, rounding
+// with mode when d is between two multiples. The result has as many decimal
+// places as increment. A zero or negative increment leaves d as it is.
+func (d Decimal) RoundToIncrement(increment Decimal, mode RoundingMode) Decimal {
+	if increment.Sign() <= 0 {
+		return d
+	}
+
+	scale := maxScale(d, increment)
+	divisor := increment.rescale(scale)
+	q, r := new(big.Int).QuoRem(new(big.Int).Abs(d.rescale(scale)), divisor, new(big.Int))
+	if mode.roundsAway(q, r, divisor) {
+		q.Add(q, big.NewInt(1))
+	}
+	if d.Sign() < 0 {
+		q.Neg(q)
+	}
+	return newDecimal(q.Mul(q, increment.int()), increment.scale)
+}
+
+// IsMultipleOf reports whether d is a whole number of increments. Every
+// decimal is a multiple of a zero increment.
+func (d Decimal) IsMultipleOf(increment Decimal) bool {
+	if increment.IsZero() {
+		return true
+	}
+
+	scale := maxScale(d, increment)
+	r := new(big.Int).Rem(d.rescale(scale), increment.rescale(scale))
+	return r.Sign() == 0
+}
+
+// Float64 returns the float64 closest to d
+func (d Decimal) Float64() float64 {
+	f, _ := new(big.Rat).SetFrac(d.int(), pow10(d.scale)).Float64()
+	return f
+}
+
+// String formats d in plain notation with all of its decimal places
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.int()).String()
+	if d.scale > 0 {
+		if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+			digits = strings.Repeat("0", pad) + digits
+		}
+		digits = digits[:len(digits)-int(d.scale)] + "." + digits[len(digits)-int(d.scale):]
+	}
+
+	if d.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// MarshalText encodes d as it is formatted by String
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes d in plain notation
+func (d *Decimal) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON encodes d as a JSON string, so clients don't lose precision
+// by reading it as a floating point number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+// UnmarshalJSON decodes d from either a JSON string or number. An empty
+// string is decoded as 0, and null leaves d unchanged.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return fmt.Errorf("Invalid decimal %s", s)
+		}
+		if unquoted == "" {
+			*d = Decimal{}
+			return nil
+		}
+		s = unquoted
+	}
+
+	return d.UnmarshalText([]byte(s))
+}
+
+func maxScale(d, e Decimal) int32 {
+	if d.scale > e.scale {
+		return d.scale
+	}
+	return e.scale
+}
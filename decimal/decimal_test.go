@@ -0,0 +1,242 @@
+package decimal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeepsDecimalPlaces(t *testing.T) {
+	tests := map[string]string{
+		"2.24":   "2.24",
+		"2.50":   "2.50",
+		"0.0001": "0.0001",
+		"-3":     "-3",
+		"+3.1":   "3.1",
+		".5":     "0.5",
+		"-.05":   "-0.05",
+		"007.10": "7.10",
+		"123456789012345678901234567890.123456789": "123456789012345678901234567890.123456789",
+	}
+
+	for in, want := range tests {
+		t.Run(in, func(t *testing.T) {
+			d, err := Parse(in)
+			assert.NoError(t, err)
+			assert.Equal(t, want, d.String())
+		})
+	}
+}
+
+func TestParseRejectsInvalidDecimals(t *testing.T) {
+	for _, in := range []string{"", " 1", "1 ", "abc", "1.2.3", "1.", ".", "-", "1e5", "0x10", "1,000", "NaN", "Inf"} {
+		t.Run(in, func(t *testing.T) {
+			_, err := Parse(in)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestZeroValueIsZero(t *testing.T) {
+	var d Decimal
+	assert.True(t, d.IsZero())
+	assert.Equal(t, "0", d.String())
+	assert.Equal(t, "1.5", d.Add(MustParse("1.5")).String())
+	assert.Equal(t, 0, d.Cmp(MustParse("0.00")))
+}
+
+func TestNewFromFloatUsesShortestDecimal(t *testing.T) {
+	tests := map[float64]string{
+		0.1:   "0.1",
+		1.005: "1.005",
+		2.675: "2.675",
+		-42:   "-42",
+		1e21:  "1000000000000000000000",
+	}
+
+	for in, want := range tests {
+		d, err := NewFromFloat(in)
+		assert.NoError(t, err)
+		assert.Equal(t, want, d.String())
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in       string
+		places   int32
+		halfUp   string
+		halfEven string
+		truncate string
+	}{
+		{"2.344", 2, "2.34", "2.34", "2.34"},
+		{"2.345", 2, "2.35", "2.34", "2.34"},
+		{"2.355", 2, "2.36", "2.36", "2.35"},
+		{"2.3451", 2, "2.35", "2.35", "2.34"},
+		{"2.349", 2, "2.35", "2.35", "2.34"},
+		{"-2.345", 2, "-2.35", "-2.34", "-2.34"},
+		{"-2.355", 2, "-2.36", "-2.36", "-2.35"},
+		{"-2.349", 2, "-2.35", "-2.35", "-2.34"},
+		// Carries into the integer part
+		{"9.995", 2, "10.00", "10.00", "9.99"},
+		{"-9.995", 2, "-10.00", "-10.00", "-9.99"},
+		{"0.5", 0, "1", "0", "0"},
+		{"1.5", 0, "2", "2", "1"},
+		{"2.5", 0, "3", "2", "2"},
+		{"-0.5", 0, "-1", "0", "0"},
+		// Rounds to zero
+		{"0.004", 2, "0.00", "0.00", "0.00"},
+		{"0.005", 2, "0.01", "0.00", "0.00"},
+		// Adds decimal places rather than losing any
+		{"2.3", 2, "2.30", "2.30", "2.30"},
+		{"2", 4, "2.0000", "2.0000", "2.0000"},
+		{"2.34", 2, "2.34", "2.34", "2.34"},
+		// Too large for a float64 to hold exactly
+		{"123456789012345678901.125", 2, "123456789012345678901.13", "123456789012345678901.12", "123456789012345678901.12"},
+		{"0.30000000000000004", 2, "0.30", "0.30", "0.30"},
+		// A float64 holds 1.005 as 1.00499999999999989...
+		{"1.005", 2, "1.01", "1.00", "1.00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			d := MustParse(test.in)
+			assert.Equal(t, test.halfUp, d.Round(test.places, HalfUp).String(), "half-up")
+			assert.Equal(t, test.halfEven, d.Round(test.places, HalfEven).String(), "half-even")
+			assert.Equal(t, test.truncate, d.Round(test.places, Truncate).String(), "truncate")
+		})
+	}
+}
+
+func TestRoundDoesNotChangeOriginal(t *testing.T) {
+	d := MustParse("2.345")
+	d.Round(2, HalfUp)
+	d.Round(4, HalfUp)
+	assert.Equal(t, "2.345", d.String())
+}
+
+func TestArithmetic(t *testing.T) {
+	a, b := MustParse("0.1"), MustParse("0.20")
+
+	assert.Equal(t, "0.30", a.Add(b).String())
+	assert.Equal(t, "-0.10", a.Sub(b).String())
+	assert.Equal(t, "0.020", a.Mul(b).String())
+	assert.Equal(t, "-0.1", a.Neg().String())
+	assert.Equal(t, "0.1", a.Neg().Abs().String())
+	assert.Equal(t, "0.1", a.String())
+}
+
+func TestCmpIgnoresDecimalPlaces(t *testing.T) {
+	assert.Equal(t, 0, MustParse("2.5").Cmp(MustParse("2.500")))
+	assert.Equal(t, -1, MustParse("2.49").Cmp(MustParse("2.5")))
+	assert.Equal(t, 1, MustParse("-2.49").Cmp(MustParse("-2.5")))
+	assert.Equal(t, 1, MustParse("100000000000000000001").Cmp(MustParse("100000000000000000000.99")))
+}
+
+func TestFloat64(t *testing.T) {
+	assert.Equal(t, 2.24, MustParse("2.24").Float64())
+	assert.Equal(t, -0.5, MustParse("-0.50").Float64())
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		Price    Decimal `json:"price"`
+		Quantity Decimal `json:"quantity"`
+		Filled   Decimal `json:"filled"`
+	}
+
+	err := json.Unmarshal([]byte(`{"price": "2.50", "quantity": 1.25, "filled": ""}`), &v)
+	assert.NoError(t, err)
+	assert.Equal(t, "2.50", v.Price.String())
+	assert.Equal(t, "1.25", v.Quantity.String())
+	assert.True(t, v.Filled.IsZero())
+
+	b, err := json.Marshal(v)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"price": "2.50", "quantity": "1.25", "filled": "0"}`, string(b))
+}
+
+func TestJSONRejectsInvalidDecimals(t *testing.T) {
+	for _, in := range []string{`"abc"`, `"1e5"`, `1e5`, `true`, `{}`} {
+		var d Decimal
+		assert.Error(t, json.Unmarshal([]byte(in), &d), in)
+	}
+}
+
+func TestRoundingModeText(t *testing.T) {
+	for _, mode := range []RoundingMode{HalfUp, HalfEven, Truncate} {
+		text, err := mode.MarshalText()
+		assert.NoError(t, err)
+
+		var got RoundingMode
+		assert.NoError(t, got.UnmarshalText(text))
+		assert.Equal(t, mode, got)
+	}
+
+	var m RoundingMode
+	assert.Error(t, m.UnmarshalText([]byte("half-down")))
+}
+
+func TestRoundToIncrement(t *testing.T) {
+	tests := []struct {
+		in        string
+		increment string
+		halfUp    string
+		halfEven  string
+		truncate  string
+	}{
+		{"2.24", "0.05", "2.25", "2.25", "2.20"},
+		{"2.225", "0.05", "2.25", "2.20", "2.20"},
+		{"2.275", "0.05", "2.30", "2.30", "2.25"},
+		{"2.2", "0.05", "2.20", "2.20", "2.20"},
+		{"-2.225", "0.05", "-2.25", "-2.20", "-2.20"},
+		{"1.23456", "0.0001", "1.2346", "1.2346", "1.2345"},
+		{"1.23455", "0.0001", "1.2346", "1.2346", "1.2345"},
+		{"1.23445", "0.0001", "1.2345", "1.2344", "1.2344"},
+		{"102.5", "1", "103", "102", "102"},
+		{"103.5", "1", "104", "104", "103"},
+		{"0.49", "1", "0", "0", "0"},
+		{"12", "5", "10", "10", "10"},
+		{"12.5", "5", "15", "10", "10"},
+		{"2.24", "0.25", "2.25", "2.25", "2.00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in+"/"+test.increment, func(t *testing.T) {
+			d, inc := MustParse(test.in), MustParse(test.increment)
+			assert.Equal(t, test.halfUp, d.RoundToIncrement(inc, HalfUp).String(), "half-up")
+			assert.Equal(t, test.halfEven, d.RoundToIncrement(inc, HalfEven).String(), "half-even")
+			assert.Equal(t, test.truncate, d.RoundToIncrement(inc, Truncate).String(), "truncate")
+		})
+	}
+}
+
+func TestRoundToIncrementIgnoresNonPositiveIncrement(t *testing.T) {
+	d := MustParse("2.245")
+	assert.Equal(t, "2.245", d.RoundToIncrement(Decimal{}, HalfUp).String())
+	assert.Equal(t, "2.245", d.RoundToIncrement(MustParse("-0.01"), HalfUp).String())
+}
+
+func TestIsMultipleOf(t *testing.T) {
+	tests := []struct {
+		in        string
+		increment string
+		multiple  bool
+	}{
+		{"2.25", "0.05", true},
+		{"2.250", "0.05", true},
+		{"2.24", "0.05", false},
+		{"1.2345", "0.0001", true},
+		{"1.23456", "0.0001", false},
+		{"100", "1", true},
+		{"100.5", "1", false},
+		{"-0.10", "0.05", true},
+		{"0", "0.05", true},
+		{"1.23456", "0", true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.multiple, MustParse(test.in).IsMultipleOf(MustParse(test.increment)), "%s of %s", test.in, test.increment)
+	}
+}
@@ -0,0 +1,73 @@
+package decimal
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// RoundingMode decides which way a decimal is rounded when it loses decimal
+// places. The zero value is HalfUp.
+type RoundingMode int
+
+const (
+	// HalfUp rounds to the nearest value, and away from zero when exactly
+	// half way, so 2.345 is 2.35 and -2.345 is -2.35
+	HalfUp RoundingMode = iota
+	// HalfEven rounds to the nearest value, and to the even neighbour when
+	// exactly half way, so 2.345 is 2.34 and 2.355 is 2.36. It avoids the
+	// upward bias of HalfUp over many roundings.
+	HalfEven
+	// Truncate drops the extra decimal places, rounding toward zero
+	Truncate
+)
+
+var roundingModeNames = map[RoundingMode]string{
+	HalfUp:   "half-up",
+	HalfEven: "half-even",
+	Truncate: "truncate",
+}
+
+func (m RoundingMode) String() string {
+	if name, ok := roundingModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("RoundingMode(%d)", int(m))
+}
+
+// MarshalText encodes the rounding mode as its name, such as half-even
+func (m RoundingMode) MarshalText() ([]byte, error) {
+	name, ok := roundingModeNames[m]
+	if !ok {
+		return nil, fmt.Errorf("Unknown rounding mode %d", int(m))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes the rounding mode from its name, such as half-even
+func (m *RoundingMode) UnmarshalText(text []byte) error {
+	for mode, name := range roundingModeNames {
+		if name == string(text) {
+			*m = mode
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown rounding mode %q, expected half-up, half-even or truncate", text)
+}
+
+// roundsAway reports whether a non-negative value with quotient q and
+// remainder r when divided by divisor should be rounded up to q+1
+func (m RoundingMode) roundsAway(q, r, divisor *big.Int) bool {
+	if r.Sign() == 0 {
+		return false
+	}
+
+	switch m {
+	case HalfUp:
+		return new(big.Int).Lsh(r, 1).Cmp(divisor) >= 0
+	case HalfEven:
+		c := new(big.Int).Lsh(r, 1).Cmp(divisor)
+		return c > 0 || (c == 0 && q.Bit(0) == 1)
+	default:
+		return false
+	}
+}
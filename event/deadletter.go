@@ -0,0 +1,152 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// GroupRedrive is the queue group used to move dead letters back onto the
+// topics they came from
+const GroupRedrive string = "redrive"
+
+// Backlogger is able to say how far a group has to read a topic to catch up
+// with it
+type Backlogger interface {
+	// Backlog returns the end of each partition of the topic that the group
+	// hasn't consumed to the end of, as the offset the next message written
+	// to it will be given
+	Backlog(ctx context.Context, topic string, group string) (map[int]int64, error)
+}
+
+// DeadLetterTopic returns the topic that messages read from topic are sent to
+// when they can't be processed
+func DeadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// DeadLetter is a message that couldn't be processed, along with why and
+// where it was read from
+type DeadLetter struct {
+	Payload   []byte    `json:"payload"`
+	Error     string    `json:"error"`
+	Topic     string    `json:"topic"`
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PublishDeadLetter sends m to the dead-letter topic for the topic it was
+// read from, recording cause as the reason it couldn't be processed
+func PublishDeadLetter(p Publisher, m Message, cause error) error {
+	dl := DeadLetter{
+		Payload:   m.Value,
+		Error:     cause.Error(),
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Timestamp: time.Now().UTC(),
+	}
+
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal dead letter: %s", err)
+	}
+
+	return p.Publish(b, DeadLetterTopic(m.Topic))
+}
+
+// Redrive moves dead letters from the dead-letter topic for topic back onto
+// the topic they were originally read from, and returns how many were moved.
+// If q is a Backlogger, it stops once it has moved the dead letters that were
+// there when it started, leaving any written since, such as trades that
+// failed again, for the next redrive. Otherwise it stops once no dead letter
+// has arrived for idle. It also stops if no dead letter arrives for idle
+// after the first, or ctx is cancelled.
+func Redrive(ctx context.Context, q ListenPublisher, topic string, idle time.Duration) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var ends map[int]int64
+	if b, ok := q.(Backlogger); ok {
+		var err error
+		ends, err = b.Backlog(ctx, DeadLetterTopic(topic), GroupRedrive)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to get dead letter backlog: %w", err)
+		}
+		if len(ends) == 0 {
+			return 0, nil
+		}
+	}
+
+	deadLetters, err := q.Subscribe(ctx, DeadLetterTopic(topic), GroupRedrive)
+	if err != nil {
+		return 0, err
+	}
+
+	// With a backlog to wait for, the first dead letter can take as long as
+	// joining the group does
+	var idleTimer <-chan time.Time
+	if ends == nil {
+		idleTimer = time.After(idle)
+	}
+
+	moved := 0
+	for {
+		select {
+		case m, ok := <-deadLetters:
+			if !ok {
+				return moved, nil
+			}
+
+			if ends != nil {
+				if end, ok := ends[m.Partition]; !ok || m.Offset >= end {
+					// Left unacked, so the next redrive moves it
+					return moved, nil
+				}
+			}
+
+			ok, err := redrive(q, m)
+			if err != nil {
+				return moved, err
+			}
+			if ok {
+				moved++
+			}
+
+			if ends != nil && m.Offset >= ends[m.Partition]-1 {
+				delete(ends, m.Partition)
+				if len(ends) == 0 {
+					return moved, nil
+				}
+			}
+			idleTimer = time.After(idle)
+		case <-idleTimer:
+			return moved, nil
+		}
+	}
+}
+
+// redrive republishes the dead letter in m to the topic it came from and acks
+// it, returning false if it was malformed and skipped
+func redrive(p Publisher, m Message) (bool, error) {
+	dl := DeadLetter{}
+	if err := json.Unmarshal(m.Value, &dl); err != nil {
+		// TODO: Change logger
+		log.Printf("Skipping malformed dead letter at offset %d: %s", m.Offset, err)
+		m.Ack()
+		return false, nil
+	}
+
+	if err := p.Publish(dl.Payload, dl.Topic); err != nil {
+		m.Nack()
+		return false, fmt.Errorf("Failed to republish dead letter to %s: %s", dl.Topic, err)
+	}
+
+	if err := m.Ack(); err != nil {
+		return false, fmt.Errorf("Failed to ack dead letter: %s", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,122 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishDeadLetterSendsMessageToDeadLetterTopic(t *testing.T) {
+	q := &MemoryQueue{}
+
+	deadLetters, err := q.Subscribe(context.Background(), "buyer-trade.dlq", GroupRedrive)
+	assert.NoError(t, err)
+
+	m := Message{
+		Value:     []byte("not a trade"),
+		Topic:     TopicBuyerTrade,
+		Partition: 3,
+		Offset:    42,
+	}
+	err = PublishDeadLetter(q, m, errors.New("invalid character"))
+	assert.NoError(t, err)
+
+	dl := DeadLetter{}
+	err = json.Unmarshal(receive(t, deadLetters).Value, &dl)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "not a trade", string(dl.Payload))
+	assert.Equal(t, "invalid character", dl.Error)
+	assert.Equal(t, TopicBuyerTrade, dl.Topic)
+	assert.Equal(t, 3, dl.Partition)
+	assert.Equal(t, int64(42), dl.Offset)
+	assert.WithinDuration(t, time.Now(), dl.Timestamp, time.Minute)
+}
+
+func TestRedriveMovesDeadLettersBackOntoTheirTopic(t *testing.T) {
+	q := &MemoryQueue{Delivery: AtLeastOnce}
+
+	for _, payload := range []string{"first", "second"} {
+		m := Message{Value: []byte(payload), Topic: TopicSellerTrade}
+		err := PublishDeadLetter(q, m, errors.New("failed"))
+		assert.NoError(t, err)
+	}
+
+	moved, err := Redrive(context.Background(), q, TopicSellerTrade, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, moved)
+
+	trades, err := q.Subscribe(context.Background(), TopicSellerTrade, GroupSeller)
+	assert.NoError(t, err)
+
+	m := receive(t, trades)
+	assert.Equal(t, "first", string(m.Value))
+	m.Ack()
+	assert.Equal(t, "second", string(receive(t, trades).Value))
+}
+
+func TestRedriveDoesNotRepeatDeadLettersAlreadyMoved(t *testing.T) {
+	q := &MemoryQueue{Delivery: AtLeastOnce}
+
+	m := Message{Value: []byte("hello"), Topic: TopicSellerTrade}
+	err := PublishDeadLetter(q, m, errors.New("failed"))
+	assert.NoError(t, err)
+
+	moved, err := Redrive(context.Background(), q, TopicSellerTrade, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, moved)
+
+	moved, err = Redrive(context.Background(), q, TopicSellerTrade, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved)
+}
+
+// failingAgainQueue dead-letters every message redriven onto a topic again,
+// as if it had failed again
+type failingAgainQueue struct {
+	*MemoryQueue
+}
+
+func (q *failingAgainQueue) Publish(message []byte, topic string) error {
+	if err := q.MemoryQueue.Publish(message, topic); err != nil {
+		return err
+	}
+	if topic == TopicSellerTrade {
+		return PublishDeadLetter(q.MemoryQueue, Message{Value: message, Topic: topic}, errors.New("failed again"))
+	}
+	return nil
+}
+
+func TestRedriveStopsAtDeadLettersWrittenSinceItStarted(t *testing.T) {
+	q := &failingAgainQueue{MemoryQueue: &MemoryQueue{Delivery: AtLeastOnce}}
+
+	for _, payload := range []string{"first", "second"} {
+		m := Message{Value: []byte(payload), Topic: TopicSellerTrade}
+		err := PublishDeadLetter(q.MemoryQueue, m, errors.New("failed"))
+		assert.NoError(t, err)
+	}
+
+	start := time.Now()
+	moved, err := Redrive(context.Background(), q, TopicSellerTrade, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	assert.WithinDuration(t, start, time.Now(), 5*time.Second)
+
+	backlog, err := q.Backlog(context.Background(), DeadLetterTopic(TopicSellerTrade), GroupRedrive)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int64{0: 4}, backlog)
+}
+
+func TestRedriveReturnsStraightAwayWithNoDeadLetters(t *testing.T) {
+	q := &MemoryQueue{Delivery: AtLeastOnce}
+
+	start := time.Now()
+	moved, err := Redrive(context.Background(), q, TopicSellerTrade, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved)
+	assert.WithinDuration(t, start, time.Now(), 5*time.Second)
+}
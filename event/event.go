@@ -3,10 +3,14 @@ package event
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/segmentio/kafka-go"
+	"github.com/stevestotter/assignment-server/decimal"
 )
 
 //go:generate go run -mod=mod github.com/golang/mock/mockgen --build_flags=-mod=mod --source=event.go --destination=../mocks/event/event.go
@@ -20,6 +24,9 @@ const (
 	TopicBuyerAssignment string = "buyer-assignment"
 	// TopicSellerAssignment is the queue topic for new seller assignments in the market
 	TopicSellerAssignment string = "seller-assignment"
+	// TopicMarketHalt is the queue topic for halts in the market, when the
+	// generator stops issuing assignments
+	TopicMarketHalt string = "market-halt"
 
 	// GroupBuyer is the queue group for buyers in the market
 	GroupBuyer string = "buyer"
@@ -27,16 +34,49 @@ const (
 	GroupSeller string = "seller"
 )
 
+// ForInstrument namespaces a topic or group to the market for the instrument
+// with the given symbol, such as EURUSD.buyer-trade. The market with no
+// symbol uses the topic or group as it is.
+func ForInstrument(symbol, name string) string {
+	if symbol == "" {
+		return name
+	}
+	return symbol + "." + name
+}
+
+// ForAgent addresses an assignment topic to a single agent, such as
+// buyer-assignment.alice, so only that agent receives the assignments on it.
+// An empty agent uses the topic as it is, shared by every agent in its group.
+func ForAgent(agent, topic string) string {
+	if agent == "" {
+		return topic
+	}
+	return topic + "." + agent
+}
+
 var (
 	//ErrQueueWrite is an error thrown on write to the event queue
 	ErrQueueWrite error = errors.New("Error on kafka write")
+	//ErrQueueClosed is an error thrown on write to an event queue that has been closed
+	ErrQueueClosed error = errors.New("Event queue is closed")
 )
 
 // Trade contains information about a trade in the market
 type Trade struct {
-	AssignmentID int    `json:"assignmentId"`
-	Price        string `json:"price"`
-	Quantity     string `json:"quantity"`
+	AssignmentID int             `json:"assignmentId"`
+	Instrument   string          `json:"instrument,omitempty"`
+	Price        decimal.Decimal `json:"price"`
+	Quantity     decimal.Decimal `json:"quantity"`
+}
+
+// MarketHalt is published when the generator stops issuing assignments in
+// a market because its prices moved too far too quickly
+type MarketHalt struct {
+	Instrument string `json:"instrument,omitempty"`
+	// Move is the percentage generated prices moved by that tripped the halt
+	Move      float64   `json:"move"`
+	HaltedAt  time.Time `json:"haltedAt"`
+	ResumesAt time.Time `json:"resumesAt"`
 }
 
 // ListenPublisher has the responsibility of both reading and publishing to
@@ -44,6 +84,10 @@ type Trade struct {
 type ListenPublisher interface {
 	Listener
 	Publisher
+
+	// Close waits for pending writes to the event queue to complete and
+	// releases its resources
+	Close() error
 }
 
 // Publisher is able to send messages to an event queue
@@ -51,73 +95,460 @@ type Publisher interface {
 	Publish(message []byte, topic string) error
 }
 
+// Envelope is a message addressed to a topic. Key identifies the message to
+// consumers, so they can dedupe it. If it is empty, a random key is used.
+type Envelope struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// BatchPublisher is able to send several messages to an event queue together
+type BatchPublisher interface {
+	PublishBatch(messages []Envelope) error
+}
+
 // Listener is able to listen for messages on a topic on the event queue
 // as part of a group. Being part of a group means two listeners of the
 // same group don't both receive the same message, and instead consume
-// messages on the topic as a team.
+// messages on the topic as a team. Listening stops when ctx is cancelled,
+// at which point the returned channel is closed.
 type Listener interface {
-	Subscribe(topic string, group string) (<-chan []byte, error)
+	Subscribe(ctx context.Context, topic string, group string) (<-chan Message, error)
 }
 
-// KafkaQueue is a Kafka Event Queue that conforms to ListenPublisher
+// KafkaQueue is a Kafka Event Queue that conforms to ListenPublisher.
+// Messages are published through one long-lived writer per topic, which is
+// created on first use and shared between concurrent publishers.
 type KafkaQueue struct {
 	URL string
+
+	// Delivery is the guarantee made to subscribers. With AtLeastOnce, each
+	// subscriber is given one message at a time and its offset is only
+	// committed once it is acked.
+	Delivery Delivery
+
+	// BatchSize is the number of messages buffered for a partition before
+	// they are sent. Defaults to 100.
+	BatchSize int
+	// BatchTimeout is how often incomplete batches are sent. Defaults to 1s.
+	BatchTimeout time.Duration
+	// RequiredAcks is the number of replicas that must acknowledge a write,
+	// where -1 means all of them. Defaults to all.
+	RequiredAcks int
+	// Retry is how writes that fail with a retriable error are tried again
+	Retry RetryPolicy
+	// Topics is how topics that don't exist yet are created when they're
+	// first published or subscribed to
+	Topics TopicSettings
+
+	// ReadMinBytes is how much a reader waits to have been written before
+	// fetching it. Defaults to 10KB.
+	ReadMinBytes int
+	// ReadMaxWait is the longest a reader waits for ReadMinBytes before
+	// fetching what there is. Defaults to 10s.
+	ReadMaxWait time.Duration
+
+	mu      sync.Mutex
+	closed  bool
+	writing sync.WaitGroup
+	writers map[string]*kafka.Writer
+	// readers holds the subscription of each live consumer group
+	readers map[*kafka.ConsumerGroup]*kafkaSubscription
+	// topics holds the topics the queue has made sure exist
+	topics map[string]bool
+	// ends holds the offset the next message written to each partition being
+	// read will be given, as last asked of kafka
+	ends     map[topicPartition]int64
+	watching bool
+	// closeCtx is cancelled when the queue is closed, so publishes stop
+	// retrying rather than holding up the close
+	closeCtx    context.Context
+	cancelClose context.CancelFunc
+}
+
+// closeContext returns a context that is cancelled when the queue is closed.
+// The caller must hold k.mu.
+func (k *KafkaQueue) closeContext() context.Context {
+	if k.closeCtx == nil {
+		k.closeCtx, k.cancelClose = context.WithCancel(context.Background())
+	}
+	return k.closeCtx
 }
 
-// Publish sends a message to the kafka queue
+// writer returns the writer for the topic, creating it if it doesn't exist
+// yet. The caller must hold k.mu.
+func (k *KafkaQueue) writer(topic string) *kafka.Writer {
+	if k.writers == nil {
+		k.writers = make(map[string]*kafka.Writer)
+	}
+
+	w, ok := k.writers[topic]
+	if !ok {
+		w = kafka.NewWriter(kafka.WriterConfig{
+			Brokers:      []string{k.URL},
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			BatchSize:    k.BatchSize,
+			BatchTimeout: k.BatchTimeout,
+			RequiredAcks: k.RequiredAcks,
+			// Retries are left to k.Retry, so they can be told apart from
+			// failures that won't succeed however many times they're tried
+			MaxAttempts: 1,
+		})
+		k.writers[topic] = w
+	}
+
+	return w
+}
+
+// Publish sends a message to the kafka queue, retrying according to k.Retry.
+// If it can't be sent, a *PublishError is returned.
 func (k *KafkaQueue) Publish(message []byte, topic string) error {
-	w := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  []string{k.URL},
-		Topic:    topic,
-		Balancer: &kafka.Hash{},
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return ErrQueueClosed
+	}
+	w := k.writer(topic)
+	ctx := k.closeContext()
+	k.writing.Add(1)
+	k.mu.Unlock()
+	defer k.writing.Done()
+
+	if err := k.ensureTopic(ctx, topic); err != nil {
+		return &PublishError{Topic: topic, Attempts: 1, Err: err}
+	}
+
+	return k.write(ctx, w, topic, kafka.Message{
+		Key:   []byte(uuid.New().String()),
+		Value: message,
 	})
-	defer w.Close()
+}
 
-	err := w.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(uuid.New().String()),
-			Value: message,
-		},
-	)
+// PublishBatch sends the messages to the kafka queue, writing the messages
+// for each topic as a single batch. Kafka can't write to several topics
+// atomically, so if a topic's batch can't be sent, the batches before it
+// will already have been.
+func (k *KafkaQueue) PublishBatch(messages []Envelope) error {
+	topics := []string{}
+	batches := make(map[string][]kafka.Message)
+	for _, e := range messages {
+		if _, ok := batches[e.Topic]; !ok {
+			topics = append(topics, e.Topic)
+		}
+		key := e.Key
+		if len(key) == 0 {
+			key = []byte(uuid.New().String())
+		}
+		batches[e.Topic] = append(batches[e.Topic], kafka.Message{Key: key, Value: e.Value})
+	}
 
-	if err != nil {
-		// TODO: Change logger
-		log.Printf("Error on kafka write: %s", err)
-		return ErrQueueWrite
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return ErrQueueClosed
+	}
+	writers := make(map[string]*kafka.Writer, len(topics))
+	for _, topic := range topics {
+		writers[topic] = k.writer(topic)
 	}
+	ctx := k.closeContext()
+	k.writing.Add(1)
+	k.mu.Unlock()
+	defer k.writing.Done()
 
+	for _, topic := range topics {
+		if err := k.ensureTopic(ctx, topic); err != nil {
+			return &PublishError{Topic: topic, Attempts: 1, Err: err}
+		}
+	}
+	for _, topic := range topics {
+		if err := k.write(ctx, writers[topic], topic, batches[topic]...); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Subscribe listens for messages on the kafka queue
-func (k *KafkaQueue) Subscribe(topic string, group string) (<-chan []byte, error) {
-	mChan := make(chan []byte)
+// write sends messages to the topic through w, retrying according to
+// k.Retry until ctx is cancelled. If they can't be sent, a *PublishError is
+// returned.
+func (k *KafkaQueue) write(ctx context.Context, w *kafka.Writer, topic string, messages ...kafka.Message) error {
+	start := time.Now()
+	defer func() {
+		publishDuration.WithLabelValues(topicLabel(topic)).Observe(time.Since(start).Seconds())
+	}()
 
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  []string{k.URL},
-		GroupID:  group,
-		Topic:    topic,
-		MinBytes: 10e3, // 10KB
-		MaxBytes: 10e6, // 10MB
+	for attempt := 1; ; attempt++ {
+		err := w.WriteMessages(context.Background(), messages...)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= k.Retry.MaxAttempts || !IsRetriable(err) || ctx.Err() != nil {
+			// TODO: Change logger
+			log.Printf("Error on kafka write: %s", err)
+			publishErrors.WithLabelValues(topicLabel(topic)).Inc()
+			return &PublishError{Topic: topic, Attempts: attempt, Err: err}
+		}
+
+		delay := k.Retry.delay(attempt)
+		// TODO: Change logger
+		log.Printf("Error on kafka write, retrying in %s: %s", delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			// TODO: Change logger
+			log.Printf("Error on kafka write, not retrying as queue is closed: %s", err)
+			publishErrors.WithLabelValues(topicLabel(topic)).Inc()
+			return &PublishError{Topic: topic, Attempts: attempt, Err: err}
+		}
+	}
+}
+
+// Close stops the queue accepting new messages, stops messages that are
+// currently being published from being retried and waits for them, then
+// flushes and closes the topic writers
+func (k *KafkaQueue) Close() error {
+	k.mu.Lock()
+	k.closed = true
+	if k.cancelClose != nil {
+		k.cancelClose()
+	}
+	k.mu.Unlock()
+
+	k.writing.Wait()
+
+	var closeErr error
+	for topic, w := range k.writers {
+		if err := w.Close(); err != nil {
+			// TODO: Change logger
+			log.Printf("Error closing kafka writer for %s: %s", topic, err)
+			closeErr = err
+		}
+	}
+
+	return closeErr
+}
+
+// Subscribe listens for messages on the kafka queue until ctx is cancelled.
+// Each generation of the group, the subscriber reads the partitions it has
+// been assigned until the generation ends.
+func (k *KafkaQueue) Subscribe(ctx context.Context, topic string, group string) (<-chan Message, error) {
+	if err := k.ensureTopic(ctx, topic); err != nil {
+		// The group retries until the topic exists, so it may still be
+		// created some other way, or once kafka can be reached
+		// TODO: Change logger
+		log.Printf("Error creating topic to subscribe to: %s", err)
+	}
+
+	cg, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:      group,
+		Brokers: []string{k.URL},
+		Topics:  []string{topic},
 	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create consumer group %s: %w", group, err)
+	}
+
+	mChan := make(chan Message)
+
+	sub := &kafkaSubscription{Subscription: Subscription{Topic: topic, Group: group}}
+	k.mu.Lock()
+	if k.readers == nil {
+		k.readers = make(map[*kafka.ConsumerGroup]*kafkaSubscription)
+	}
+	k.readers[cg] = sub
+	if !k.watching {
+		k.watching = true
+		go k.watchEnds(k.closeContext())
+	}
+	k.mu.Unlock()
 
 	go func() {
 		defer func() {
-			r.Close()
+			// Closing the group ends its generation, and waits for the
+			// partitions being read to stop
+			if err := cg.Close(); err != nil {
+				// TODO: Change logger
+				log.Printf("Error closing kafka consumer group: %s\n", err)
+			}
+
+			k.mu.Lock()
+			delete(k.readers, cg)
+			k.mu.Unlock()
 			close(mChan)
 		}()
 
 		for {
-			m, err := r.ReadMessage(context.Background())
+			gen, err := cg.Next(ctx)
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				// TODO: Change logger
-				log.Printf("Error on kafka read: %s\n", err)
+				log.Printf("Error joining kafka consumer group %s: %s\n", group, err)
 				continue
 			}
-			mChan <- m.Value
+			k.consume(gen, sub, mChan)
 		}
 	}()
 
 	return mChan, nil
+}
+
+// consume reads each partition assigned to the subscription in the generation
+// until the generation ends
+func (k *KafkaQueue) consume(gen *kafka.Generation, sub *kafkaSubscription, mChan chan<- Message) {
+	assignments := gen.Assignments[sub.Topic]
+	assigned := make(map[int]int64, len(assignments))
+	for _, a := range assignments {
+		assigned[a.ID] = a.Offset
+	}
+
+	k.mu.Lock()
+	sub.assign(assigned)
+	k.mu.Unlock()
+
+	gen.Start(func(ctx context.Context) {
+		<-ctx.Done()
+		k.mu.Lock()
+		sub.assign(nil)
+		k.mu.Unlock()
+	})
+
+	for _, a := range assignments {
+		a := a
+		gen.Start(func(ctx context.Context) {
+			k.readPartition(ctx, gen, sub, a, mChan)
+		})
+	}
+}
+
+// readPartition delivers the messages on an assigned partition from where the
+// group last committed to, committing them to the generation, until ctx is
+// cancelled
+func (k *KafkaQueue) readPartition(ctx context.Context, gen *kafka.Generation, sub *kafkaSubscription, a kafka.PartitionAssignment, mChan chan<- Message) {
+	minBytes := k.ReadMinBytes
+	if minBytes <= 0 {
+		minBytes = 10e3 // 10KB
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{k.URL},
+		Topic:     sub.Topic,
+		Partition: a.ID,
+		MinBytes:  minBytes,
+		MaxBytes:  10e6, // 10MB
+		MaxWait:   k.ReadMaxWait,
+	})
+	defer func() {
+		if err := r.Close(); err != nil {
+			// TODO: Change logger
+			log.Printf("Error closing kafka reader: %s\n", err)
+		}
+	}()
+
+	if err := r.SetOffset(a.Offset); err != nil {
+		// TODO: Change logger
+		log.Printf("Error setting kafka reader offset: %s\n", err)
+		return
+	}
 
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// TODO: Change logger
+			log.Printf("Error on kafka read: %s\n", err)
+			continue
+		}
+
+		commit := func() error {
+			offsets := map[string]map[int]int64{m.Topic: {m.Partition: m.Offset + 1}}
+			if err := gen.CommitOffsets(offsets); err != nil {
+				return err
+			}
+			k.mu.Lock()
+			sub.committed(m)
+			k.mu.Unlock()
+			return nil
+		}
+
+		message := Message{
+			Value:     m.Value,
+			Topic:     m.Topic,
+			Partition: m.Partition,
+			Offset:    m.Offset,
+		}
+
+		if k.Delivery == AtLeastOnce {
+			if !deliver(ctx, mChan, message, commit) {
+				return
+			}
+			continue
+		}
+
+		// Committing before delivering means the message is consumed even if
+		// it's never processed. If it can't be committed, the generation is
+		// most likely over and the message is left for its next reader.
+		if err := commit(); err != nil {
+			// TODO: Change logger
+			log.Printf("Error on kafka commit: %s\n", err)
+			return
+		}
+
+		select {
+		case mChan <- message:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Backlog asks kafka for the partitions of the topic and where the group has
+// committed to in each. A group that hasn't committed to a partition reads it
+// from its first offset.
+func (k *KafkaQueue) Backlog(ctx context.Context, topic string, group string) (map[int]int64, error) {
+	client := &kafka.Client{Addr: kafka.TCP(k.URL)}
+	committed, err := client.ConsumerOffsets(ctx, kafka.TopicAndGroup{Topic: topic, GroupId: group})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get committed offsets of %s: %w", topic, err)
+	}
+
+	ends := make(map[int]int64)
+	for partition, offset := range committed {
+		first, end, err := k.partitionOffsets(ctx, topic, partition)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get offsets of %s partition %d: %w", topic, partition, err)
+		}
+		if offset < first {
+			offset = first
+		}
+		if offset < end {
+			ends[partition] = end
+		}
+	}
+	return ends, nil
+}
+
+// partitionOffsets returns the offset of the first message in the partition,
+// and the offset the next message written to it will be given
+func (k *KafkaQueue) partitionOffsets(ctx context.Context, topic string, partition int) (int64, int64, error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", k.URL, topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn.ReadOffsets()
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
 )
@@ -87,12 +88,105 @@ func TestIntegrationKafkaQueueSubscribeListensToMessagesFromKafka(t *testing.T)
 	expectedMessage := kafka.Message{Value: []byte("hello")}
 
 	kq := &KafkaQueue{URL: kafkaAddress}
-	messageChan, err := kq.Subscribe(TopicBuyerAssignment, "a-group")
+	messageChan, err := kq.Subscribe(context.Background(), TopicBuyerAssignment, "a-group")
 
 	assert.NoError(t, err)
 
 	writeMessages(TopicBuyerAssignment, []kafka.Message{expectedMessage})
 	m := <-messageChan
 
-	assert.Equal(t, string(expectedMessage.Value), string(m))
+	assert.Equal(t, string(expectedMessage.Value), string(m.Value))
+}
+
+func TestIntegrationKafkaQueueSubscribeClosesChannelWhenContextCancelled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	kq := &KafkaQueue{URL: kafkaAddress}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messageChan, err := kq.Subscribe(ctx, TopicBuyerAssignment, "a-group")
+
+	assert.NoError(t, err)
+
+	cancel()
+
+	_, ok := <-messageChan
+	assert.False(t, ok, "expected channel to be closed")
+}
+
+func TestIntegrationKafkaQueuePublishReusesWriterForTopic(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	kq := &KafkaQueue{URL: kafkaAddress}
+	defer kq.Close()
+
+	err := kq.Publish([]byte("first"), TopicSellerAssignment)
+	assert.NoError(t, err)
+	err = kq.Publish([]byte("second"), TopicSellerAssignment)
+	assert.NoError(t, err)
+
+	assert.Len(t, kq.writers, 1)
+}
+
+func TestIntegrationKafkaQueuePublishReturnsErrorWhenClosed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	kq := &KafkaQueue{URL: kafkaAddress}
+	err := kq.Close()
+	assert.NoError(t, err)
+
+	err = kq.Publish([]byte("hello"), TopicBuyerAssignment)
+	assert.Equal(t, ErrQueueClosed, err)
+}
+
+func TestIntegrationKafkaQueueAtLeastOnceRedeliversNackedMessage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	expectedMessage := kafka.Message{Value: []byte("hello")}
+
+	kq := &KafkaQueue{URL: kafkaAddress, Delivery: AtLeastOnce}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messageChan, err := kq.Subscribe(ctx, TopicSellerAssignment, "a-group")
+
+	assert.NoError(t, err)
+
+	writeMessages(TopicSellerAssignment, []kafka.Message{expectedMessage})
+
+	m := <-messageChan
+	assert.Equal(t, string(expectedMessage.Value), string(m.Value))
+	assert.NoError(t, m.Nack())
+
+	m = <-messageChan
+	assert.Equal(t, string(expectedMessage.Value), string(m.Value))
+	assert.NoError(t, m.Ack())
+}
+
+func TestIntegrationKafkaQueuePublishCreatesAgentTopic(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	topic := ForAgent("agent-"+uuid.New().String(), TopicBuyerAssignment)
+
+	kq := &KafkaQueue{URL: kafkaAddress, Topics: TopicSettings{Partitions: 1}}
+	defer kq.Close()
+
+	err := kq.Publish([]byte("private value"), topic)
+	assert.NoError(t, err)
+
+	messageChan, done := readMessages(topic, 1)
+	m := <-messageChan
+
+	assert.Equal(t, "private value", string(m))
+	<-done
 }
@@ -0,0 +1,145 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Subscription is a group's subscription to a topic
+type Subscription struct {
+	Topic string `json:"topic"`
+	Group string `json:"group"`
+}
+
+// SubscriptionStatus is the state of a live subscription. Joined is whether
+// the subscriber currently has a share of the topic from its group, which is
+// for information only, as a subscriber can be left without one.
+type SubscriptionStatus struct {
+	Subscription
+	Joined bool `json:"joined"`
+}
+
+// HealthChecker is able to report whether an event queue is usable
+type HealthChecker interface {
+	// Ping returns an error if the event queue can't be reached
+	Ping(ctx context.Context) error
+	// Subscriptions returns the state of the subscriptions that are live
+	Subscriptions() []SubscriptionStatus
+}
+
+func sortStatuses(statuses []SubscriptionStatus) []SubscriptionStatus {
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Topic != statuses[j].Topic {
+			return statuses[i].Topic < statuses[j].Topic
+		}
+		return statuses[i].Group < statuses[j].Group
+	})
+	return statuses
+}
+
+// Ping returns ErrQueueClosed once the queue is closed
+func (q *MemoryQueue) Ping(_ context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	return nil
+}
+
+// Subscriptions returns the topics and groups with a live subscriber. Groups
+// in memory are joined as soon as they subscribe.
+func (q *MemoryQueue) Subscriptions() []SubscriptionStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	statuses := make([]SubscriptionStatus, 0, len(q.listeners))
+	for sub := range q.listeners {
+		statuses = append(statuses, SubscriptionStatus{Subscription: sub, Joined: true})
+	}
+	return sortStatuses(statuses)
+}
+
+// Ping connects to the broker and asks it for the brokers in its cluster
+func (k *KafkaQueue) Ping(ctx context.Context) error {
+	k.mu.Lock()
+	closed := k.closed
+	k.mu.Unlock()
+	if closed {
+		return ErrQueueClosed
+	}
+
+	conn, err := kafka.DialContext(ctx, "tcp", k.URL)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to kafka: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Brokers(); err != nil {
+		return fmt.Errorf("Failed to get kafka brokers: %w", err)
+	}
+	return nil
+}
+
+// Subscriptions returns the topics and groups with a live consumer group. A
+// group has joined while its current generation assigns it partitions, which
+// it may not if there are more subscribers than partitions.
+func (k *KafkaQueue) Subscriptions() []SubscriptionStatus {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	statuses := make([]SubscriptionStatus, 0, len(k.readers))
+	for _, sub := range k.readers {
+		statuses = append(statuses, SubscriptionStatus{Subscription: sub.Subscription, Joined: sub.joined()})
+	}
+	return sortStatuses(statuses)
+}
+
+// kafkaSubscription is the state of a live consumer group, built up from its
+// generations and the messages it has committed
+type kafkaSubscription struct {
+	Subscription
+	// assigned holds the partitions the group has given the subscriber in its
+	// current generation, with the offset it started reading each from
+	assigned map[int]int64
+	// commits holds the offset of the next message to be read from each
+	// assigned partition, as committed to the group
+	commits map[int]int64
+}
+
+// joined reports whether the subscriber currently has partitions to read
+func (s *kafkaSubscription) joined() bool {
+	return len(s.assigned) > 0
+}
+
+// assign starts a generation in which the subscriber reads the partitions in
+// assigned from the offsets the group last committed, which are negative if
+// it hasn't committed any
+func (s *kafkaSubscription) assign(assigned map[int]int64) {
+	s.assigned = assigned
+	s.commits = make(map[int]int64, len(assigned))
+	for partition, offset := range assigned {
+		if offset >= 0 {
+			s.commits[partition] = offset
+		}
+	}
+}
+
+// committed records that m has been committed to the group, if its partition
+// is still assigned to the reader
+func (s *kafkaSubscription) committed(m kafka.Message) {
+	if _, ok := s.assigned[m.Partition]; !ok {
+		return
+	}
+	if s.commits == nil {
+		s.commits = make(map[int]int64)
+	}
+	s.commits[m.Partition] = m.Offset + 1
+}
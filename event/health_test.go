@@ -0,0 +1,34 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaQueueSubscriptionJoinsAndLeavesWithItsGenerations(t *testing.T) {
+	k := &KafkaQueue{}
+	sub := &kafkaSubscription{Subscription: Subscription{Topic: "buyer-trade", Group: "assignment-server"}}
+	k.readers = map[*kafka.ConsumerGroup]*kafkaSubscription{{}: sub}
+
+	joined := func() bool {
+		statuses := k.Subscriptions()
+		return assert.Len(t, statuses, 1) && statuses[0].Joined
+	}
+
+	assert.False(t, joined())
+
+	sub.assign(map[int]int64{0: 4, 1: 9})
+	assert.True(t, joined())
+
+	sub.assign(nil)
+	assert.False(t, joined())
+
+	sub.assign(map[int]int64{0: 5})
+	assert.True(t, joined())
+
+	// More subscribers than partitions leaves some with none
+	sub.assign(map[int]int64{})
+	assert.False(t, joined())
+}
@@ -0,0 +1,229 @@
+package event
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryQueue is an in-process Event Queue that conforms to ListenPublisher.
+// Like Kafka, every group subscribed to a topic sees every message on it, and
+// listeners within the same group share those messages between them. Messages
+// are kept for the lifetime of the queue, so it is suited to local runs and
+// tests rather than production.
+type MemoryQueue struct {
+	// Delivery is the guarantee made to subscribers. With AtLeastOnce, each
+	// subscriber is given one message at a time, and a message that hasn't
+	// been acked when its subscriber stops is given to another in its group.
+	Delivery Delivery
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	topics map[string]*memoryTopic
+	closed bool
+	// listeners counts the live subscriptions to each topic and group
+	listeners map[Subscription]int
+}
+
+type memoryTopic struct {
+	messages [][]byte
+	// offsets holds the index of the next message to be consumed by each group
+	offsets map[string]int
+	// unacked holds messages each group must consume again before moving on
+	unacked map[string][]Message
+}
+
+// topic returns the named topic, creating it if it doesn't exist yet. The
+// caller must hold q.mu.
+func (q *MemoryQueue) topic(name string) *memoryTopic {
+	if q.topics == nil {
+		q.topics = make(map[string]*memoryTopic)
+		q.cond = sync.NewCond(&q.mu)
+	}
+
+	t, ok := q.topics[name]
+	if !ok {
+		t = &memoryTopic{
+			offsets: make(map[string]int),
+			unacked: make(map[string][]Message),
+		}
+		q.topics[name] = t
+	}
+
+	return t
+}
+
+// Publish appends a message to the topic in memory
+func (q *MemoryQueue) Publish(message []byte, topic string) error {
+	m := make([]byte, len(message))
+	copy(m, message)
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+	t := q.topic(topic)
+	t.messages = append(t.messages, m)
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+	return nil
+}
+
+// PublishBatch appends every message to its topic in memory at once, so
+// subscribers never see part of the batch. Message keys aren't kept.
+func (q *MemoryQueue) PublishBatch(messages []Envelope) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+	if len(messages) == 0 {
+		q.mu.Unlock()
+		return nil
+	}
+	for _, e := range messages {
+		m := make([]byte, len(e.Value))
+		copy(m, e.Value)
+
+		t := q.topic(e.Topic)
+		t.messages = append(t.messages, m)
+	}
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+	return nil
+}
+
+// Close stops the queue accepting new messages. Subscriptions end once they
+// have consumed the messages already published. Publishing to memory is
+// synchronous, so there are never writes left to wait on.
+func (q *MemoryQueue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	if q.cond != nil {
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// Subscribe listens for messages on the in-memory topic until ctx is
+// cancelled. A group that hasn't subscribed before starts from the first
+// message published to the topic.
+func (q *MemoryQueue) Subscribe(ctx context.Context, topic string, group string) (<-chan Message, error) {
+	mChan := make(chan Message)
+
+	sub := Subscription{Topic: topic, Group: group}
+	q.mu.Lock()
+	q.topic(topic)
+	if q.listeners == nil {
+		q.listeners = make(map[Subscription]int)
+	}
+	q.listeners[sub]++
+	q.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+
+		// Wake any listener waiting on a new message so it sees the
+		// cancellation
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}()
+
+	go func() {
+		defer close(stopped)
+		defer func() {
+			q.mu.Lock()
+			if q.listeners[sub]--; q.listeners[sub] == 0 {
+				delete(q.listeners, sub)
+			}
+			q.mu.Unlock()
+			close(mChan)
+		}()
+
+		for {
+			m, ok := q.next(ctx, topic, group)
+			if !ok {
+				return
+			}
+
+			if q.Delivery == AtLeastOnce {
+				if !deliver(ctx, mChan, m, func() error { return nil }) {
+					q.requeue(topic, group, m)
+					return
+				}
+				continue
+			}
+
+			select {
+			case mChan <- m:
+			case <-ctx.Done():
+				q.requeue(topic, group, m)
+				return
+			}
+		}
+	}()
+
+	return mChan, nil
+}
+
+// next blocks until there is a message on the topic the group hasn't consumed
+// yet, then claims it for the caller. It returns false if ctx is cancelled,
+// or the queue is closed, before a message arrives.
+func (q *MemoryQueue) next(ctx context.Context, topic string, group string) (Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	t := q.topic(topic)
+	for len(t.unacked[group]) == 0 && t.offsets[group] >= len(t.messages) {
+		if ctx.Err() != nil || q.closed {
+			return Message{}, false
+		}
+		q.cond.Wait()
+	}
+
+	if unacked := t.unacked[group]; len(unacked) > 0 {
+		t.unacked[group] = unacked[1:]
+		return unacked[0], true
+	}
+
+	offset := t.offsets[group]
+	t.offsets[group]++
+	return Message{
+		Value:  t.messages[offset],
+		Topic:  topic,
+		Offset: int64(offset),
+	}, true
+}
+
+// requeue hands a message that was claimed but never delivered or acked back
+// to the group
+func (q *MemoryQueue) requeue(topic string, group string, m Message) {
+	q.mu.Lock()
+	t := q.topic(topic)
+	t.unacked[group] = append(t.unacked[group], m)
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+}
+
+// Backlog returns the end of the topic as its only partition, if the group
+// hasn't consumed every message on it
+func (q *MemoryQueue) Backlog(_ context.Context, topic string, group string) (map[int]int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	t := q.topic(topic)
+	if len(t.unacked[group]) == 0 && t.offsets[group] >= len(t.messages) {
+		return map[int]int64{}, nil
+	}
+	return map[int]int64{0: int64(len(t.messages))}, nil
+}
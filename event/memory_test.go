@@ -0,0 +1,312 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, mChan <-chan Message) Message {
+	select {
+	case m := <-mChan:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return Message{}
+	}
+}
+
+func TestMemoryQueueSubscribeReceivesPublishedMessage(t *testing.T) {
+	q := &MemoryQueue{}
+
+	messageChan, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	err = q.Publish([]byte("hello"), TopicBuyerTrade)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "hello", string(receive(t, messageChan).Value))
+}
+
+func TestMemoryQueueSubscribeReceivesMessagesPublishedBeforeSubscribing(t *testing.T) {
+	q := &MemoryQueue{}
+
+	err := q.Publish([]byte("first"), TopicBuyerTrade)
+	assert.NoError(t, err)
+	err = q.Publish([]byte("second"), TopicBuyerTrade)
+	assert.NoError(t, err)
+
+	messageChan, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "first", string(receive(t, messageChan).Value))
+	assert.Equal(t, "second", string(receive(t, messageChan).Value))
+}
+
+func TestMemoryQueueDeliversEachMessageToEveryGroup(t *testing.T) {
+	q := &MemoryQueue{}
+
+	buyers, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+	sellers, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupSeller)
+	assert.NoError(t, err)
+
+	err = q.Publish([]byte("hello"), TopicBuyerTrade)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "hello", string(receive(t, buyers).Value))
+	assert.Equal(t, "hello", string(receive(t, sellers).Value))
+}
+
+func TestMemoryQueueDeliversEachMessageToOneListenerInGroup(t *testing.T) {
+	q := &MemoryQueue{}
+
+	first, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+	second, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	numMessages := 10
+	for i := 0; i < numMessages; i++ {
+		err = q.Publish([]byte("hello"), TopicBuyerTrade)
+		assert.NoError(t, err)
+	}
+
+	received := 0
+	timeout := time.After(time.Second)
+	for received < numMessages {
+		select {
+		case <-first:
+			received++
+		case <-second:
+			received++
+		case <-timeout:
+			t.Fatalf("timed out after receiving %d of %d messages", received, numMessages)
+		}
+	}
+
+	select {
+	case <-first:
+		t.Fatal("message delivered more than once within group")
+	case <-second:
+		t.Fatal("message delivered more than once within group")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryQueueSubscribeDoesNotReceiveMessagesFromOtherTopics(t *testing.T) {
+	q := &MemoryQueue{}
+
+	messageChan, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	err = q.Publish([]byte("hello"), TopicSellerTrade)
+	assert.NoError(t, err)
+
+	select {
+	case m := <-messageChan:
+		t.Fatalf("unexpected message received: %s", m.Value)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryQueueSubscribeClosesChannelWhenContextCancelled(t *testing.T) {
+	q := &MemoryQueue{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messageChan, err := q.Subscribe(ctx, TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	cancel()
+
+	select {
+	case _, ok := <-messageChan:
+		assert.False(t, ok, "expected channel to be closed")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for channel to close")
+	}
+}
+
+func TestMemoryQueuePublishReturnsErrorWhenClosed(t *testing.T) {
+	q := &MemoryQueue{}
+
+	err := q.Close()
+	assert.NoError(t, err)
+
+	err = q.Publish([]byte("hello"), TopicBuyerTrade)
+	assert.Equal(t, ErrQueueClosed, err)
+}
+
+func TestMemoryQueueAtLeastOnceDeliversNextMessageOnceAcked(t *testing.T) {
+	q := &MemoryQueue{Delivery: AtLeastOnce}
+
+	messageChan, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	q.Publish([]byte("first"), TopicBuyerTrade)
+	q.Publish([]byte("second"), TopicBuyerTrade)
+
+	m := receive(t, messageChan)
+	assert.Equal(t, "first", string(m.Value))
+
+	select {
+	case m := <-messageChan:
+		t.Fatalf("message %s delivered before previous was acked", m.Value)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.NoError(t, m.Ack())
+	assert.Equal(t, "second", string(receive(t, messageChan).Value))
+}
+
+func TestMemoryQueueAtLeastOnceRedeliversNackedMessage(t *testing.T) {
+	nackRedeliveryDelay = 0
+	defer func() { nackRedeliveryDelay = time.Second }()
+
+	q := &MemoryQueue{Delivery: AtLeastOnce}
+
+	messageChan, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	q.Publish([]byte("first"), TopicBuyerTrade)
+	q.Publish([]byte("second"), TopicBuyerTrade)
+
+	m := receive(t, messageChan)
+	assert.Equal(t, "first", string(m.Value))
+	assert.NoError(t, m.Nack())
+
+	m = receive(t, messageChan)
+	assert.Equal(t, "first", string(m.Value))
+	assert.NoError(t, m.Ack())
+
+	assert.Equal(t, "second", string(receive(t, messageChan).Value))
+}
+
+func TestMemoryQueueAtLeastOnceRedeliversUnackedMessageToGroupWhenCancelled(t *testing.T) {
+	q := &MemoryQueue{Delivery: AtLeastOnce}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := q.Subscribe(ctx, TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	q.Publish([]byte("hello"), TopicBuyerTrade)
+
+	assert.Equal(t, "hello", string(receive(t, first).Value))
+	cancel()
+
+	second, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "hello", string(receive(t, second).Value))
+}
+
+func TestMemoryQueueAtMostOnceRedeliversUndeliveredMessageToGroupWhenCancelled(t *testing.T) {
+	q := &MemoryQueue{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := q.Subscribe(ctx, TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	q.Publish([]byte("hello"), TopicBuyerTrade)
+
+	// Wait for the message to be claimed without receiving it
+	assert.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.topic(TopicBuyerTrade).offsets[GroupBuyer] == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	for range first {
+	}
+
+	second, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "hello", string(receive(t, second).Value))
+}
+
+func TestMemoryQueueSubscribeClosesChannelOnceQueueClosed(t *testing.T) {
+	q := &MemoryQueue{}
+
+	q.Publish([]byte("hello"), TopicBuyerTrade)
+	messageChan, err := q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	assert.NoError(t, q.Close())
+
+	assert.Equal(t, "hello", string(receive(t, messageChan).Value))
+	select {
+	case _, ok := <-messageChan:
+		assert.False(t, ok, "expected channel to be closed")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for channel to close")
+	}
+}
+
+func TestMemoryQueuePublishBatchSendsEachMessageToItsTopic(t *testing.T) {
+	q := &MemoryQueue{}
+
+	buyers, err := q.Subscribe(context.Background(), TopicBuyerAssignment, GroupBuyer)
+	assert.NoError(t, err)
+	sellers, err := q.Subscribe(context.Background(), TopicSellerAssignment, GroupSeller)
+	assert.NoError(t, err)
+
+	err = q.PublishBatch([]Envelope{
+		{Topic: TopicBuyerAssignment, Value: []byte("first")},
+		{Topic: TopicSellerAssignment, Value: []byte("second")},
+		{Topic: TopicBuyerAssignment, Value: []byte("third")},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "first", string(receive(t, buyers).Value))
+	assert.Equal(t, "third", string(receive(t, buyers).Value))
+	assert.Equal(t, "second", string(receive(t, sellers).Value))
+}
+
+func TestMemoryQueuePublishBatchAcceptsEmptyBatch(t *testing.T) {
+	q := &MemoryQueue{}
+
+	assert.NoError(t, q.PublishBatch(nil))
+}
+
+func TestMemoryQueuePublishBatchReturnsErrorWhenClosed(t *testing.T) {
+	q := &MemoryQueue{}
+	assert.NoError(t, q.Close())
+
+	err := q.PublishBatch([]Envelope{{Topic: TopicBuyerAssignment, Value: []byte("hello")}})
+	assert.Equal(t, ErrQueueClosed, err)
+}
+
+func TestMemoryQueueSubscriptionsListsLiveSubscriptions(t *testing.T) {
+	q := &MemoryQueue{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sellers, err := q.Subscribe(ctx, TopicSellerTrade, GroupSeller)
+	assert.NoError(t, err)
+	_, err = q.Subscribe(context.Background(), TopicBuyerTrade, GroupBuyer)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []SubscriptionStatus{
+		{Subscription: Subscription{Topic: TopicBuyerTrade, Group: GroupBuyer}, Joined: true},
+		{Subscription: Subscription{Topic: TopicSellerTrade, Group: GroupSeller}, Joined: true},
+	}, q.Subscriptions())
+
+	cancel()
+	for range sellers {
+	}
+
+	assert.Equal(t, []SubscriptionStatus{
+		{Subscription: Subscription{Topic: TopicBuyerTrade, Group: GroupBuyer}, Joined: true},
+	}, q.Subscriptions())
+}
+
+func TestMemoryQueuePingReturnsErrorWhenClosed(t *testing.T) {
+	q := &MemoryQueue{}
+	assert.NoError(t, q.Ping(context.Background()))
+
+	assert.NoError(t, q.Close())
+	assert.Equal(t, ErrQueueClosed, q.Ping(context.Background()))
+}
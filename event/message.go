@@ -0,0 +1,118 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Delivery is the guarantee a Listener makes about delivering each message
+// on a topic to its group
+type Delivery int
+
+const (
+	// AtMostOnce marks a message as consumed as soon as it is read, before
+	// it's processed. Ack and Nack have no effect.
+	AtMostOnce Delivery = iota
+	// AtLeastOnce only marks a message as consumed once it is acked. A
+	// message that is nacked, or that listening stops before acking, is
+	// delivered again.
+	AtLeastOnce
+)
+
+// nackRedeliveryDelay is how long a nacked message waits before being
+// delivered again, so a consumer that keeps failing doesn't spin
+var nackRedeliveryDelay = time.Second
+
+// UnmarshalText parses a delivery guarantee from either at-most-once or
+// at-least-once
+func (d *Delivery) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "at-most-once":
+		*d = AtMostOnce
+	case "at-least-once":
+		*d = AtLeastOnce
+	default:
+		return fmt.Errorf("Unknown delivery %q, expected at-most-once or at-least-once", text)
+	}
+	return nil
+}
+
+// Message is a message received from the event queue. Consumers should Ack
+// a message once they have finished processing it, or Nack it if it should
+// be delivered again.
+type Message struct {
+	Value     []byte
+	Topic     string
+	Partition int
+	Offset    int64
+
+	settle func(ack bool) error
+}
+
+// Ack marks the message as consumed, so it won't be delivered again
+func (m Message) Ack() error {
+	if m.settle == nil {
+		return nil
+	}
+	return m.settle(true)
+}
+
+// Nack marks the message as not consumed, so it will be delivered again
+func (m Message) Nack() error {
+	if m.settle == nil {
+		return nil
+	}
+	return m.settle(false)
+}
+
+// settlement records the outcome of a delivered message, which can only be
+// settled once
+type settlement struct {
+	once   sync.Once
+	commit func() error
+	result chan bool
+}
+
+func (s *settlement) settle(ack bool) error {
+	var err error
+	s.once.Do(func() {
+		if ack {
+			err = s.commit()
+		}
+		s.result <- ack
+	})
+	return err
+}
+
+// deliver sends m to mChan and waits for the consumer to settle it, calling
+// commit when it is acked and sending it again when it is nacked. It returns
+// false if ctx is done before the message is acked.
+func deliver(ctx context.Context, mChan chan<- Message, m Message, commit func() error) bool {
+	for {
+		s := &settlement{commit: commit, result: make(chan bool, 1)}
+		m.settle = s.settle
+
+		select {
+		case mChan <- m:
+		case <-ctx.Done():
+			return false
+		}
+
+		select {
+		case ack := <-s.result:
+			if ack {
+				return true
+			}
+		case <-ctx.Done():
+			return false
+		}
+
+		select {
+		case <-time.After(nackRedeliveryDelay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
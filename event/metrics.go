@@ -0,0 +1,165 @@
+package event
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	publishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "assignment_server",
+		Name:      "publish_duration_seconds",
+		Help:      "How long publishing to a kafka topic took, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic"})
+
+	publishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "assignment_server",
+		Name:      "publish_errors_total",
+		Help:      "Publishes to a kafka topic that failed after all retries.",
+	}, []string{"topic"})
+
+	consumerLagDesc = prometheus.NewDesc(
+		"assignment_server_consumer_lag",
+		"Messages on a kafka partition that a group's live reader has not committed yet.",
+		[]string{"topic", "group", "partition"}, nil,
+	)
+)
+
+const (
+	// endsInterval is how often kafka is asked for the end of each partition
+	// being read, to work out consumer lag
+	endsInterval = 15 * time.Second
+	// lagTimeout is how long kafka is given to say where a partition ends
+	lagTimeout = 2 * time.Second
+)
+
+// topicPartition is a partition of a kafka topic
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+// Describe sends the descriptions of the metrics collected by Collect
+func (k *KafkaQueue) Describe(ch chan<- *prometheus.Desc) {
+	ch <- consumerLagDesc
+}
+
+// Collect sends the consumer lag of each partition assigned to a live reader,
+// from the offset its group last committed to where the partition ended when
+// kafka was last asked
+func (k *KafkaQueue) Collect(ch chan<- prometheus.Metric) {
+	metrics := []prometheus.Metric{}
+
+	k.mu.Lock()
+	for _, sub := range k.readers {
+		for partition, committed := range sub.commits {
+			end, ok := k.ends[topicPartition{topic: sub.Topic, partition: partition}]
+			if !ok {
+				continue
+			}
+
+			metrics = append(metrics, prometheus.MustNewConstMetric(consumerLagDesc, prometheus.GaugeValue,
+				float64(lag(end, committed)), sub.Topic, sub.Group, strconv.Itoa(partition)))
+		}
+	}
+	k.mu.Unlock()
+
+	for _, m := range metrics {
+		ch <- m
+	}
+}
+
+// watchEnds asks kafka for the end of each partition being read every
+// endsInterval, until ctx is cancelled
+func (k *KafkaQueue) watchEnds(ctx context.Context) {
+	ticker := time.NewTicker(endsInterval)
+	defer ticker.Stop()
+
+	for {
+		k.updateEnds(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// updateEnds asks kafka for the end of each partition assigned to a live
+// reader
+func (k *KafkaQueue) updateEnds(ctx context.Context) {
+	k.mu.Lock()
+	partitions := make(map[topicPartition]bool)
+	for _, sub := range k.readers {
+		for partition := range sub.assigned {
+			partitions[topicPartition{topic: sub.Topic, partition: partition}] = true
+		}
+	}
+	k.mu.Unlock()
+
+	ends := make(map[topicPartition]int64, len(partitions))
+	for tp := range partitions {
+		end, err := k.partitionEnd(ctx, tp.topic, tp.partition)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// TODO: Change logger
+			log.Printf("Error getting end of %s partition %d: %s", tp.topic, tp.partition, err)
+			continue
+		}
+		ends[tp] = end
+	}
+
+	k.mu.Lock()
+	k.ends = ends
+	k.mu.Unlock()
+}
+
+// partitionEnd returns the offset the next message written to the partition
+// will be given
+func (k *KafkaQueue) partitionEnd(ctx context.Context, topic string, partition int) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, lagTimeout)
+	defer cancel()
+
+	conn, err := kafka.DialLeader(ctx, "tcp", k.URL, topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn.ReadLastOffset()
+}
+
+// topicLabel is the topic label a publish to topic is recorded under. Each
+// agent's topics are recorded under the assignment topic they address, so
+// the series don't grow with the number of agents.
+func topicLabel(topic string) string {
+	for _, base := range []string{TopicBuyerAssignment, TopicSellerAssignment} {
+		if i := strings.Index(topic, base+"."); i >= 0 {
+			return topic[:i+len(base)]
+		}
+	}
+	return topic
+}
+
+// lag is the number of messages in a partition ending at end that come at or
+// after the committed offset
+func lag(end, committed int64) int64 {
+	if behind := end - committed; behind > 0 {
+		return behind
+	}
+	return 0
+}
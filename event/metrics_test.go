@@ -0,0 +1,59 @@
+package event
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaSubscriptionRecordsCommitsToAssignedPartitions(t *testing.T) {
+	sub := &kafkaSubscription{}
+
+	sub.assign(map[int]int64{0: 3, 1: -1, 2: 7})
+	assert.Equal(t, map[int]int64{0: 3, 2: 7}, sub.commits)
+
+	sub.committed(kafka.Message{Partition: 0, Offset: 4})
+	sub.committed(kafka.Message{Partition: 1, Offset: 9})
+	sub.committed(kafka.Message{Partition: 5, Offset: 2})
+	assert.Equal(t, map[int]int64{0: 5, 1: 10, 2: 7}, sub.commits)
+
+	sub.assign(nil)
+	sub.committed(kafka.Message{Partition: 0, Offset: 5})
+	assert.Empty(t, sub.commits)
+}
+
+func TestKafkaQueueCollectsLagFromCommittedOffsets(t *testing.T) {
+	sub := &kafkaSubscription{Subscription: Subscription{Topic: TopicBuyerTrade, Group: GroupBuyer}}
+	sub.assign(map[int]int64{0: 3, 1: 8, 2: 0})
+	k := &KafkaQueue{
+		readers: map[*kafka.ConsumerGroup]*kafkaSubscription{{}: sub},
+		ends: map[topicPartition]int64{
+			{topic: TopicBuyerTrade, partition: 0}: 10,
+			{topic: TopicBuyerTrade, partition: 1}: 8,
+		},
+	}
+
+	expected := `
+		# HELP assignment_server_consumer_lag Messages on a kafka partition that a group's live reader has not committed yet.
+		# TYPE assignment_server_consumer_lag gauge
+		assignment_server_consumer_lag{group="` + GroupBuyer + `",partition="0",topic="buyer-trade"} 7
+		assignment_server_consumer_lag{group="` + GroupBuyer + `",partition="1",topic="buyer-trade"} 0
+	`
+	assert.NoError(t, testutil.CollectAndCompare(k, strings.NewReader(expected)))
+}
+
+func TestLag(t *testing.T) {
+	assert.Equal(t, int64(5), lag(10, 5))
+	assert.Equal(t, int64(0), lag(10, 10))
+	assert.Equal(t, int64(0), lag(0, 5))
+}
+
+func TestTopicLabelRecordsAgentTopicsUnderTheirAssignmentTopic(t *testing.T) {
+	assert.Equal(t, "buyer-assignment", topicLabel(ForAgent("trader-7", TopicBuyerAssignment)))
+	assert.Equal(t, "EURUSD.seller-assignment", topicLabel(ForAgent("trader-7", ForInstrument("EURUSD", TopicSellerAssignment))))
+	assert.Equal(t, "EURUSD.seller-assignment", topicLabel(ForInstrument("EURUSD", TopicSellerAssignment)))
+	assert.Equal(t, "buyer-trade.dlq", topicLabel(DeadLetterTopic(TopicBuyerTrade)))
+}
@@ -0,0 +1,129 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// RetryPolicy decides how many times, and how often, a write to the event
+// queue that failed with a retriable error is tried again. The zero value
+// never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a write is tried, including the first
+	MaxAttempts int
+	// BaseDelay is how long to wait before the first retry. The delay
+	// doubles for each retry after that.
+	BaseDelay time.Duration
+	// MaxDelay caps how long to wait between any two attempts
+	MaxDelay time.Duration
+	// Jitter is the fraction of each delay, between 0 and 1, that is
+	// randomised so writers that fail together don't retry together
+	Jitter float64
+}
+
+// delay returns how long to wait before the given retry, starting at 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+
+	return d
+}
+
+// PublishError is returned when a message couldn't be published to the event
+// queue. It matches ErrQueueWrite with errors.Is, and unwraps to the error
+// returned by the queue on the last attempt.
+type PublishError struct {
+	Topic    string
+	Attempts int
+	Err      error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("%s to %s after %d attempt(s): %s", ErrQueueWrite, e.Topic, e.Attempts, e.Err)
+}
+
+// Unwrap returns the error returned by the queue on the last attempt
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrQueueWrite
+func (e *PublishError) Is(target error) bool {
+	return target == ErrQueueWrite
+}
+
+// IsRetriable reports whether a failed kafka write could succeed if it was
+// tried again, such as while a partition leader is being elected or a broker
+// is restarting. A *PublishError is retriable if its last attempt failed with
+// a retriable error.
+func IsRetriable(err error) bool {
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, e := range writeErrs {
+			if e != nil && !IsRetriable(e) {
+				return false
+			}
+		}
+		return writeErrs.Count() > 0
+	}
+
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Temporary()
+	}
+
+	if errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+
+	return false
+}
+
+// MayHaveWritten reports whether a failed kafka write may have reached kafka
+// anyway, because it timed out before kafka said whether it had been written
+func MayHaveWritten(err error) bool {
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, e := range writeErrs {
+			if e != nil && MayHaveWritten(e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if errors.Is(err, kafka.RequestTimedOut) ||
+		errors.Is(err, kafka.NotEnoughReplicasAfterAppend) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
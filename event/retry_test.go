@@ -0,0 +1,139 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelayDoublesUpToMaxDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, p.delay(1))
+	assert.Equal(t, 200*time.Millisecond, p.delay(2))
+	assert.Equal(t, 400*time.Millisecond, p.delay(3))
+	assert.Equal(t, 800*time.Millisecond, p.delay(4))
+	assert.Equal(t, time.Second, p.delay(5))
+	assert.Equal(t, time.Second, p.delay(50))
+}
+
+func TestRetryPolicyDelayWithJitterStaysWithinJitterFraction(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		d := p.delay(2)
+		assert.True(t, d > 100*time.Millisecond && d <= 200*time.Millisecond,
+			"delay %s outside of jitter range", d)
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	tests := map[string]struct {
+		err       error
+		retriable bool
+	}{
+		"Leader not available":     {err: kafka.LeaderNotAvailable, retriable: true},
+		"Not leader for partition": {err: kafka.NotLeaderForPartition, retriable: true},
+		"Request timed out":        {err: kafka.RequestTimedOut, retriable: true},
+		"Topic not authorized":     {err: kafka.TopicAuthorizationFailed, retriable: false},
+		"Message too large":        {err: kafka.MessageSizeTooLarge, retriable: false},
+		"Connection closed":        {err: io.ErrUnexpectedEOF, retriable: true},
+		"Wrapped temporary error":  {err: fmt.Errorf("write: %w", kafka.LeaderNotAvailable), retriable: true},
+		"Unknown error":            {err: errors.New("unknown"), retriable: false},
+		"Write errors all temporary": {
+			err:       kafka.WriteErrors{kafka.LeaderNotAvailable, nil},
+			retriable: true,
+		},
+		"Write errors with fatal error": {
+			err:       kafka.WriteErrors{kafka.LeaderNotAvailable, kafka.MessageSizeTooLarge},
+			retriable: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.retriable, IsRetriable(tc.err))
+		})
+	}
+}
+
+func TestMayHaveWritten(t *testing.T) {
+	tests := map[string]struct {
+		err     error
+		written bool
+	}{
+		"Request timed out":    {err: kafka.RequestTimedOut, written: true},
+		"Deadline exceeded":    {err: context.DeadlineExceeded, written: true},
+		"Not enough replicas":  {err: kafka.NotEnoughReplicasAfterAppend, written: true},
+		"Leader not available": {err: kafka.LeaderNotAvailable, written: false},
+		"Connection refused":   {err: syscall.ECONNREFUSED, written: false},
+		"Publish timed out": {
+			err:     &PublishError{Topic: TopicBuyerAssignment, Attempts: 3, Err: kafka.RequestTimedOut},
+			written: true,
+		},
+		"Write errors with timeout": {
+			err:     kafka.WriteErrors{nil, kafka.RequestTimedOut},
+			written: true,
+		},
+		"Write errors without timeout": {
+			err:     kafka.WriteErrors{kafka.LeaderNotAvailable, nil},
+			written: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.written, MayHaveWritten(tc.err))
+		})
+	}
+}
+
+func TestPublishErrorMatchesErrQueueWriteAndUnwrapsCause(t *testing.T) {
+	err := fmt.Errorf("Failed to publish assignment: %w",
+		&PublishError{Topic: TopicBuyerAssignment, Attempts: 3, Err: kafka.LeaderNotAvailable})
+
+	assert.True(t, errors.Is(err, ErrQueueWrite))
+	assert.True(t, errors.Is(err, kafka.LeaderNotAvailable))
+	assert.Contains(t, err.Error(), "buyer-assignment after 3 attempt(s)")
+}
+
+func TestKafkaQueueCloseStopsPublishRetrying(t *testing.T) {
+	kq := &KafkaQueue{URL: "127.0.0.1:1", Retry: RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour}}
+
+	published := make(chan error, 1)
+	go func() {
+		published <- kq.Publish([]byte("hello"), TopicBuyerAssignment)
+	}()
+
+	// The writer is made as the publish starts
+	assert.Eventually(t, func() bool {
+		kq.mu.Lock()
+		defer kq.mu.Unlock()
+		return len(kq.writers) > 0
+	}, time.Second, time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		kq.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close waited for publish to finish retrying")
+	}
+
+	err := <-published
+	var publishErr *PublishError
+	if assert.True(t, errors.As(err, &publishErr), "%v", err) {
+		assert.Equal(t, 1, publishErr.Attempts)
+	}
+}
@@ -0,0 +1,76 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// TopicSettings is how the topics a KafkaQueue uses are created if they don't
+// exist yet, such as the topic for an agent's assignments. Zero Partitions
+// never creates topics, leaving them to be provisioned outside the server.
+type TopicSettings struct {
+	Partitions        int
+	ReplicationFactor int
+}
+
+// ensureTopic creates the topic if the queue creates topics and it hasn't
+// already made sure the topic exists
+func (k *KafkaQueue) ensureTopic(ctx context.Context, topic string) error {
+	if k.Topics.Partitions <= 0 {
+		return nil
+	}
+
+	k.mu.Lock()
+	ensured := k.topics[topic]
+	k.mu.Unlock()
+	if ensured {
+		return nil
+	}
+
+	if err := k.createTopic(ctx, topic); err != nil {
+		return fmt.Errorf("Failed to create topic %s: %w", topic, err)
+	}
+
+	k.mu.Lock()
+	if k.topics == nil {
+		k.topics = make(map[string]bool)
+	}
+	k.topics[topic] = true
+	k.mu.Unlock()
+	return nil
+}
+
+// createTopic asks the cluster's controller to create the topic, which does
+// nothing if it already exists
+func (k *KafkaQueue) createTopic(ctx context.Context, topic string) error {
+	conn, err := kafka.DialContext(ctx, "tcp", k.URL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	controller, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+
+	controllerConn, err := kafka.DialContext(ctx, "tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+
+	replication := k.Topics.ReplicationFactor
+	if replication <= 0 {
+		replication = 1
+	}
+	return controllerConn.CreateTopics(kafka.TopicConfig{
+		Topic:             topic,
+		NumPartitions:     k.Topics.Partitions,
+		ReplicationFactor: replication,
+	})
+}
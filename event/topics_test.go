@@ -0,0 +1,29 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaQueueEnsureTopicLeavesTopicsAloneByDefault(t *testing.T) {
+	kq := &KafkaQueue{URL: "127.0.0.1:1"}
+
+	assert.NoError(t, kq.ensureTopic(context.Background(), ForAgent("alice", TopicBuyerAssignment)))
+}
+
+func TestKafkaQueuePublishFailsWhenAgentTopicCantBeCreated(t *testing.T) {
+	kq := &KafkaQueue{URL: "127.0.0.1:1", Topics: TopicSettings{Partitions: 1}}
+	topic := ForAgent("alice", TopicBuyerAssignment)
+
+	err := kq.Publish([]byte("hello"), topic)
+
+	var publishErr *PublishError
+	if assert.True(t, errors.As(err, &publishErr), "%v", err) {
+		assert.Equal(t, topic, publishErr.Topic)
+		assert.Contains(t, publishErr.Error(), "Failed to create topic buyer-assignment.alice")
+	}
+	assert.False(t, kq.topics[topic])
+}
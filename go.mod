@@ -8,6 +8,9 @@ require (
 	github.com/golang/mock v1.4.4
 	github.com/google/uuid v1.1.2
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/prometheus/client_golang v1.8.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/segmentio/kafka-go v0.4.8
 	github.com/stretchr/testify v1.6.1
+	go.etcd.io/bbolt v1.3.5
 )
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/stevestotter/assignment-server/api"
 	"github.com/stevestotter/assignment-server/assignment"
 	"github.com/stevestotter/assignment-server/config"
 	"github.com/stevestotter/assignment-server/event"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
@@ -18,23 +25,257 @@ func main() {
 		log.Fatalf("Error processing env config: %s", err)
 	}
 
-	queue := &event.KafkaQueue{URL: cfg.Kafka.URL}
+	queue, err := newQueue(cfg)
+	if err != nil {
+		log.Fatalf("Error creating event queue: %s", err)
+	}
 
-	generator := assignment.Generator{
-		MessageQueue:        queue,
-		PercentageChangeMin: cfg.Generator.PercentageChangeMin,
-		PercentageChangeMax: cfg.Generator.PercentageChangeMax,
+	store, err := newStore(cfg)
+	if err != nil {
+		log.Fatalf("Error creating assignment store: %s", err)
 	}
 
-	a := api.API{Port: cfg.API.Port, AssignmentSubmitter: &generator}
+	exchange := assignment.Exchange{}
+	a := api.API{
+		Port:                cfg.API.Port,
+		AssignmentSubmitter: exchange,
+		AssignmentStore:     store,
+		Instruments:         map[string]assignment.Instrument{},
+		IdempotencyWindow:   cfg.API.IdempotencyWindow,
+		IdempotencyCapacity: cfg.API.IdempotencyCapacity,
+	}
+
+	for symbol, market := range cfg.Markets {
+		instrument := market.Instrument.Instrument()
+		instrument.Symbol = symbol
+		if err := instrument.Validate(); err != nil {
+			log.Fatalf("Invalid instrument config for %q: %s", symbol, err)
+		}
+
+		bounds := market.Generator.Bounds()
+		if err := bounds.Validate(instrument); err != nil {
+			log.Fatalf("Invalid price bounds for %q: %s", symbol, err)
+		}
+
+		quantityBounds := market.Generator.QuantityBounds()
+		if err := quantityBounds.Validate(instrument); err != nil {
+			log.Fatalf("Invalid quantity bounds for %q: %s", symbol, err)
+		}
+
+		pricing, err := newPricingStrategy(market.Generator)
+		if err != nil {
+			log.Fatalf("Error creating pricing strategy for %q: %s", symbol, err)
+		}
+
+		quantity, err := newQuantityStrategy(market.Generator)
+		if err != nil {
+			log.Fatalf("Error creating quantity strategy for %q: %s", symbol, err)
+		}
+
+		seed := market.Generator.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		log.Printf("Seeding generator for market %q with %d", symbol, seed)
+
+		exchange[symbol] = &assignment.Generator{
+			MessageQueue:   queue,
+			Store:          store,
+			Pricing:        pricing,
+			Quantity:       quantity,
+			Instrument:     instrument,
+			Rounding:       market.Generator.RoundingMode,
+			AssignmentTTL:  market.Generator.AssignmentTTL,
+			Bounds:         bounds,
+			QuantityBounds: quantityBounds,
+			Agents:         cfg.Agents,
+			CircuitBreaker: market.Generator.CircuitBreaker(),
+			Rand:           assignment.NewRand(seed),
+		}
+
+		if symbol == "" {
+			a.Instrument = instrument
+		} else {
+			a.Instruments[symbol] = instrument
+		}
+	}
+
+	a.Subscriptions = exchange.Subscriptions()
+	if checker, ok := queue.(event.HealthChecker); ok {
+		a.Queue = checker
+	}
+	if collector, ok := queue.(prometheus.Collector); ok {
+		prometheus.MustRegister(collector)
+	}
 
 	err = a.Start()
 	if err != nil {
 		log.Fatalf("Couldn't start API server: %s", err)
 	}
 
-	err = generator.GenerateFromTrades()
-	if err != nil {
-		log.Fatalf("Couldn't start generator for trades: %s", err)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	ctx, stopGenerator := context.WithCancel(context.Background())
+	generatorDone := make(chan struct{})
+	var generatorErr error
+	go func() {
+		generatorErr = exchange.GenerateFromTrades(ctx)
+		close(generatorDone)
+	}()
+
+	exitCode := 0
+
+	select {
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	case <-generatorDone:
+		if generatorErr != nil {
+			log.Printf("Couldn't start generator for trades: %s", generatorErr)
+			exitCode = 1
+		} else {
+			// The trade subscriptions ended without being stopped
+			log.Printf("Generator stopped listening to trades, shutting down")
+		}
+	}
+
+	if !shutdown(cfg, &a, stopGenerator, generatorDone, queue, store) {
+		exitCode = 1
+	}
+
+	fmt.Println("Stopped")
+	os.Exit(exitCode)
+}
+
+// shutdown fails readiness checks for the drain delay, then stops the API
+// taking new assignments, then stops the generator consuming trades, and
+// finally flushes anything still being written to the queue and store. It
+// returns false if any of these didn't complete cleanly.
+func shutdown(cfg *config.Config, a *api.API, stopGenerator context.CancelFunc,
+	generatorDone <-chan struct{}, queue event.ListenPublisher, store assignment.Store) bool {
+	ok := true
+
+	a.Drain()
+	time.Sleep(cfg.API.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.API.ShutdownTimeout)
+	defer cancel()
+
+	if err := a.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down API server: %s", err)
+		ok = false
+	}
+
+	stopGenerator()
+	select {
+	case <-generatorDone:
+	case <-ctx.Done():
+		log.Printf("Timed out waiting for generator to stop")
+		ok = false
+	}
+
+	if err := queue.Close(); err != nil {
+		log.Printf("Error closing event queue: %s", err)
+		ok = false
+	}
+
+	if err := store.Close(); err != nil {
+		log.Printf("Error closing assignment store: %s", err)
+		ok = false
+	}
+
+	return ok
+}
+
+func newQueue(cfg *config.Config) (event.ListenPublisher, error) {
+	switch cfg.Queue.Backend {
+	case "kafka":
+		return &event.KafkaQueue{
+			URL:          cfg.Kafka.URL,
+			Delivery:     cfg.Queue.Delivery,
+			BatchSize:    cfg.Kafka.WriterBatchSize,
+			BatchTimeout: cfg.Kafka.WriterBatchTimeout,
+			RequiredAcks: cfg.Kafka.WriterRequiredAcks,
+			Retry:        cfg.Kafka.RetryPolicy(),
+			Topics:       cfg.Kafka.TopicSettings(),
+		}, nil
+	case "memory":
+		return &event.MemoryQueue{Delivery: cfg.Queue.Delivery}, nil
+	default:
+		return nil, fmt.Errorf("Unknown queue backend %q, expected kafka or memory", cfg.Queue.Backend)
+	}
+}
+
+func newStore(cfg *config.Config) (assignment.Store, error) {
+	switch cfg.Store.Backend {
+	case "memory":
+		return assignment.NewMemoryStore(), nil
+	case "bolt":
+		return assignment.NewBoltStore(cfg.Store.Path)
+	default:
+		return nil, fmt.Errorf("Unknown store backend %q, expected memory or bolt", cfg.Store.Backend)
+	}
+}
+
+func newPricingStrategy(cfg config.Generator) (assignment.PricingStrategy, error) {
+	switch cfg.PricingStrategy {
+	case "percentage-change":
+		return &assignment.PercentageChange{
+			Min: cfg.PercentageChangeMin,
+			Max: cfg.PercentageChangeMax,
+		}, nil
+	case "mean-reversion":
+		if cfg.MeanReversionAnchor <= 0 {
+			return nil, fmt.Errorf("Mean reversion needs a positive anchor price, got %v", cfg.MeanReversionAnchor)
+		}
+		return &assignment.MeanReversion{
+			Anchor:     cfg.MeanReversionAnchor,
+			Speed:      cfg.MeanReversionSpeed,
+			Volatility: cfg.MeanReversionVolatility,
+			Spread:     cfg.MeanReversionSpread,
+			TimeUnit:   cfg.PricingTimeUnit,
+		}, nil
+	case "geometric-brownian-motion":
+		return &assignment.GeometricBrownianMotion{
+			InitialPrice: cfg.GBMInitialPrice,
+			Drift:        cfg.GBMDrift,
+			Volatility:   cfg.GBMVolatility,
+			Spread:       cfg.GBMSpread,
+			TimeUnit:     cfg.PricingTimeUnit,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown pricing strategy %q, expected percentage-change, mean-reversion or geometric-brownian-motion",
+			cfg.PricingStrategy)
+	}
+}
+
+func newQuantityStrategy(cfg config.Generator) (assignment.QuantityStrategy, error) {
+	switch cfg.QuantityStrategy {
+	case "trade":
+		return nil, nil
+	case "fixed":
+		if cfg.QuantityFixed.Sign() <= 0 {
+			return nil, fmt.Errorf("Fixed quantity needs a positive size, got %s", cfg.QuantityFixed)
+		}
+		return &assignment.FixedQuantity{Size: cfg.QuantityFixed}, nil
+	case "range":
+		if cfg.QuantityRangeMin.Sign() <= 0 || cfg.QuantityRangeMin.Cmp(cfg.QuantityRangeMax) > 0 {
+			return nil, fmt.Errorf("Quantity range needs a positive minimum no more than its maximum, got %s to %s",
+				cfg.QuantityRangeMin, cfg.QuantityRangeMax)
+		}
+		return &assignment.RangeQuantity{Min: cfg.QuantityRangeMin, Max: cfg.QuantityRangeMax}, nil
+	case "proportional":
+		if cfg.QuantityRatio.Sign() <= 0 {
+			return nil, fmt.Errorf("Proportional quantity needs a positive ratio, got %s", cfg.QuantityRatio)
+		}
+		return &assignment.ProportionalQuantity{Ratio: cfg.QuantityRatio}, nil
+	case "distribution":
+		if len(cfg.QuantityDistribution) == 0 {
+			return nil, fmt.Errorf("Quantity distribution needs at least one size")
+		}
+		return &assignment.DistributionQuantity{Sizes: cfg.QuantityDistribution}, nil
+	default:
+		return nil, fmt.Errorf("Unknown quantity strategy %q, expected trade, fixed, range, proportional or distribution",
+			cfg.QuantityStrategy)
 	}
 }